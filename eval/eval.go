@@ -3,6 +3,7 @@ package eval
 import (
 	"fmt"
 	"github.com/pauek/garzon/db"
+	"runtime/debug"
 	"time"
 )
 
@@ -10,6 +11,7 @@ type Submission struct {
 	User      string `json:",omitempty"`
 	ProblemID string
 	Problem   *Problem
+	Lang      string // language name (e.g. "C++")
 	Solution  string
 	Submitted time.Time
 	Resolved  time.Time
@@ -26,15 +28,60 @@ type Problem struct {
 	StatementID string
 	Solution    string
 	Evaluator   db.Obj
+	Languages   []string `json:",omitempty"` // allowed languages (all if empty)
+}
+
+func (P *Problem) Allows(lang string) bool {
+	if len(P.Languages) == 0 {
+		return true
+	}
+	for _, L := range P.Languages {
+		if L == lang {
+			return true
+		}
+	}
+	return false
 }
 
 type Veredict struct {
-	Message string
-	Details db.Obj
+	Message  string
+	Details  db.Obj
+	Warnings []string `json:",omitempty"` // compiler warnings
+}
+
+// Veredicts of failures of the judge (not of the submission)
+const (
+	InternalError = "Internal Error"         // the evaluation failed (e.g. grz-jail)
+	JudgeError    = "Judge Error"            // the judge is broken (e.g. a wrong evaluator)
+	ModelError    = "Model doesn't compile!" // the problem is broken
+)
+
+// ErrorDetails are the details of an InternalError, JudgeError or
+// ModelError veredict. The diagnostics are stored with the submission
+// for the administrators, but are not shown to the user.
+type ErrorDetails struct {
+	Diagnostics string
+}
+
+func (ed ErrorDetails) String() string {
+	return "The judge failed (the administrators have the details)\n"
+}
+
+// ErrorVeredict makes an InternalError, JudgeError or ModelError veredict.
+func ErrorVeredict(message, diagnostics string) Veredict {
+	return Veredict{
+		Message: message,
+		Details: db.Obj{&ErrorDetails{diagnostics}},
+	}
+}
+
+// IsError tells if a veredict is a failure of the judge.
+func (V *Veredict) IsError() bool {
+	return V.Message == InternalError || V.Message == JudgeError || V.Message == ModelError
 }
 
 type Evaluator interface {
-	Evaluate(Problem *Problem, Solution string, progress chan<- string) Veredict
+	Evaluate(Problem *Problem, Lang, Solution string, progress chan<- string) Veredict
 }
 
 type Response struct {
@@ -44,18 +91,32 @@ type Response struct {
 
 type Eval bool
 
+// Submit evaluates a submission, sending its progress and finally
+// "Resolved" (when V is ready). A failure of the judge (a problem
+// without evaluator, a panic while evaluating) doesn't take down the
+// caller but gives a JudgeError veredict.
 func Submit(S Submission, V *Veredict, progress chan<- string) {
+	defer func() {
+		if r := recover(); r != nil {
+			*V = ErrorVeredict(JudgeError, fmt.Sprintf("panic: %v\n\n%s", r, debug.Stack()))
+		}
+		progress <- "Resolved"
+	}()
+	if S.Problem == nil {
+		*V = ErrorVeredict(JudgeError, fmt.Sprintf("No problem for '%s'", S.ProblemID))
+		return
+	}
 	ev, ok := S.Problem.Evaluator.Obj.(Evaluator)
 	if !ok {
-		progress <- "Error: wrong evaluator"
+		*V = ErrorVeredict(JudgeError, fmt.Sprintf("Wrong evaluator (%T)", S.Problem.Evaluator.Obj))
 		return
 	}
-	*V = ev.Evaluate(S.Problem, S.Solution, progress)
-	progress <- "Resolved"
+	*V = ev.Evaluate(S.Problem, S.Lang, S.Solution, progress)
 }
 
 func init() {
 	db.Register("eval.Problem", Problem{})
 	db.Register("eval.Submission", Submission{})
 	db.Register("eval.Veredict", Veredict{})
+	db.Register("eval.ErrorDetails", ErrorDetails{})
 }
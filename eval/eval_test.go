@@ -0,0 +1,23 @@
+package eval
+
+import "testing"
+
+func TestAllows(t *testing.T) {
+	tests := []struct {
+		languages []string
+		lang      string
+		allowed   bool
+	}{
+		{nil, "C++", true}, // (all of them)
+		{[]string{"C++"}, "C++", true},
+		{[]string{"C++", "Go"}, "Go", true},
+		{[]string{"C++"}, "Go", false},
+		{[]string{"Go"}, "go", false}, // (names as in package lang)
+	}
+	for _, test := range tests {
+		P := &Problem{Languages: test.languages}
+		if P.Allows(test.lang) != test.allowed {
+			t.Errorf("%v: Allows(%q) should be %v", test.languages, test.lang, test.allowed)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package programming
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// The accused is run with an audit log (grz-jail -A), so that when it
+// makes a forbidden syscall, the TestResult has the last syscalls it
+// made (the trace), and an explanation of what it tried to do.
+
+const (
+	traceLines = 20        // syscalls in the trace of a TestResult
+	traceBytes = 64 * 1024 // read from the end of the audit log
+)
+
+// readTrace returns the last 'n' lines of the audit log (reading only
+// its last traceBytes).
+func readTrace(path string, n int) []string {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil
+	}
+	offset := info.Size() - traceBytes
+	if offset < 0 {
+		offset = 0
+	}
+	data, err := ioutil.ReadAll(io.NewSectionReader(f, offset, traceBytes))
+	if err != nil {
+		return nil
+	}
+	text := strings.TrimRight(string(data), "\n")
+	if i := strings.Index(text, "\n"); offset > 0 && i >= 0 {
+		text = text[i+1:] // (the first line is cut)
+	}
+	lines := strings.Split(text, "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return lines
+}
+
+var reprRx = regexp.MustCompile(`^(?:([a-z0-9_]+):)?([a-z0-9_]+)\((.*)\)$`)
+var filenameRx = regexp.MustCompile(`"((?:[^"\\]|\\.)*)"`)
+
+// What is done with the file in syscalls that have a filename.
+var fileActions = map[string]string{
+	"open":       "open",
+	"openat":     "open",
+	"creat":      "create",
+	"unlink":     "delete",
+	"unlinkat":   "delete",
+	"access":     "check",
+	"stat":       "check",
+	"lstat":      "check",
+	"newfstatat": "check",
+	"readlink":   "read the link",
+	"readlinkat": "read the link",
+	"truncate":   "truncate",
+	"chmod":      "change the permissions of",
+}
+
+// splitRepr splits a syscall representation, which has the ABI in
+// front if it is not the native one (e.g. 'i386:open("data")').
+func splitRepr(repr string) (abi, name, filename string) {
+	m := reprRx.FindStringSubmatch(repr)
+	if m == nil {
+		return "", repr, ""
+	}
+	abi, name = m[1], m[2]
+	if f := filenameRx.FindStringSubmatch(m[3]); f != nil {
+		filename = f[1]
+	}
+	return
+}
+
+// explainForbidden explains a forbidden syscall 'repr', given the
+// syscalls that the model made (the files it used are the allowed
+// ones).
+func explainForbidden(repr string, model []string) string {
+	abi, name, filename := splitRepr(repr)
+	if abi != "" && !usesAbi(model, abi) {
+		return fmt.Sprintf("Your program made the system call '%s' with the %s ABI "+
+			"(e.g. with 'int 0x80' in a 64-bit program), which is not allowed.", name, abi)
+	}
+	action, ok := fileActions[name]
+	if !ok || filename == "" {
+		return fmt.Sprintf("Your program made the system call '%s', "+
+			"which is not allowed (the solution doesn't need it).", name)
+	}
+	var allowed []string
+	seen := make(map[string]bool)
+	for _, m := range model {
+		_, n, f := splitRepr(m)
+		if f != "" && fileActions[n] == action && !seen[f] {
+			seen[f] = true
+			allowed = append(allowed, f)
+		}
+	}
+	switch len(allowed) {
+	case 0:
+		return fmt.Sprintf("Your program tried to %s file '%s', "+
+			"but it is not allowed to %s any file.", action, filename, action)
+	case 1:
+		return fmt.Sprintf("Your program tried to %s file '%s', "+
+			"but only '%s' is allowed.", action, filename, allowed[0])
+	}
+	return fmt.Sprintf("Your program tried to %s file '%s', "+
+		"but only '%s' are allowed.", action, filename, strings.Join(allowed, "', '"))
+}
+
+func usesAbi(model []string, abi string) bool {
+	for _, m := range model {
+		if a, _, _ := splitRepr(m); a == abi {
+			return true
+		}
+	}
+	return false
+}
+
+// readSyscalls reads the list of syscalls of the model.
+func readSyscalls(path string) []string {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	return strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+}
@@ -0,0 +1,107 @@
+package programming
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/pauek/garzon/eval/programming/lang"
+)
+
+// CompilationDiagnostics are the details of a "Compilation Error"
+// veredict: the diagnostics of the compiler, each with the line of
+// code it refers to. If the output couldn't be parsed (e.g. a linker
+// error), only the raw output is kept.
+type CompilationDiagnostics struct {
+	Diagnostics []CompilationDiagnostic `json:",omitempty"`
+	Output      string                  `json:",omitempty"`
+}
+
+type CompilationDiagnostic struct {
+	Severity     string // "error", "warning" or "note"
+	File         string
+	Line, Column int
+	Message      string
+	Excerpt      string `json:",omitempty"` // line of code
+}
+
+func newCompilationDiagnostics(err *lang.CompilationError, code string) CompilationDiagnostics {
+	if len(err.Diagnostics) == 0 {
+		return CompilationDiagnostics{Output: err.Output}
+	}
+	lines := strings.Split(code, "\n")
+	diags := make([]CompilationDiagnostic, len(err.Diagnostics))
+	for i, d := range err.Diagnostics {
+		diags[i] = CompilationDiagnostic{
+			Severity: d.Severity,
+			File:     d.File,
+			Line:     d.Line,
+			Column:   d.Column,
+			Message:  d.Message,
+		}
+		// Only the submitted file (not headers, which have a path)
+		if !strings.Contains(d.File, "/") && d.Line >= 1 && d.Line <= len(lines) {
+			diags[i].Excerpt = strings.TrimRight(lines[d.Line-1], "\r")
+		}
+	}
+	return CompilationDiagnostics{Diagnostics: diags}
+}
+
+func (cd CompilationDiagnostics) Errors() (errors []CompilationDiagnostic) {
+	for _, d := range cd.Diagnostics {
+		if d.Severity == "error" {
+			errors = append(errors, d)
+		}
+	}
+	return
+}
+
+func (cd CompilationDiagnostics) String() string {
+	if len(cd.Diagnostics) == 0 {
+		return cd.Output
+	}
+	var b bytes.Buffer
+	for _, d := range cd.Diagnostics {
+		fmt.Fprintf(&b, "%s\n", d)
+	}
+	return b.String()
+}
+
+// String shows the diagnostic as gcc does, with a caret under the
+// column of the excerpt.
+func (d CompilationDiagnostic) String() string {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%s:%d:%d: %s: %s", d.File, d.Line, d.Column, d.Severity, d.Message)
+	if d.Excerpt != "" {
+		prefix := fmt.Sprintf("%5d | ", d.Line)
+		fmt.Fprintf(&b, "\n%s%s", prefix, d.Excerpt)
+		if d.Column > 0 {
+			spaces := strings.Repeat(" ", len(prefix)-2)
+			fmt.Fprintf(&b, "\n%s| %s^", spaces, caretIndent(d.Excerpt, d.Column-1))
+		}
+	}
+	return b.String()
+}
+
+// caretIndent returns the whitespace needed to reach column 'col' of
+// 'line' (keeping tabs, so that the caret is well aligned).
+func caretIndent(line string, col int) string {
+	var b bytes.Buffer
+	for i := 0; i < col && i < len(line); i++ {
+		if line[i] == '\t' {
+			b.WriteByte('\t')
+		} else {
+			b.WriteByte(' ')
+		}
+	}
+	return b.String()
+}
+
+var quotedRx = regexp.MustCompile(`'[^']*'|"[^"]*"|‘[^’]*’`)
+
+// Kind is the message without the names in quotes, so that errors
+// can be grouped (e.g. "'_' was not declared in this scope").
+func (d CompilationDiagnostic) Kind() string {
+	return quotedRx.ReplaceAllString(d.Message, "'_'")
+}
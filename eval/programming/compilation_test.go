@@ -0,0 +1,34 @@
+package programming
+
+import (
+	"testing"
+
+	"github.com/pauek/garzon/eval/programming/lang"
+)
+
+func TestCompilationDiagnostics(t *testing.T) {
+	code := "int main() {\n\tint x; return y;\n}\n"
+	err := &lang.CompilationError{
+		Diagnostics: []lang.Diagnostic{
+			{File: "code.cc", Line: 2, Column: 16, Severity: "error",
+				Message: "'y' was not declared in this scope"},
+		},
+	}
+	cd := newCompilationDiagnostics(err, code)
+	if len(cd.Errors()) != 1 {
+		t.Fatalf("Should have 1 error (has %d)", len(cd.Errors()))
+	}
+	d := cd.Errors()[0]
+	if d.Excerpt != "\tint x; return y;" {
+		t.Errorf("Wrong excerpt '%s'", d.Excerpt)
+	}
+	expected := "code.cc:2:16: error: 'y' was not declared in this scope\n" +
+		"    2 | \tint x; return y;\n" +
+		"      | \t              ^"
+	if d.String() != expected {
+		t.Errorf("Wrong rendering:\n%s\nshould be:\n%s", d, expected)
+	}
+	if d.Kind() != "'_' was not declared in this scope" {
+		t.Errorf("Wrong kind '%s'", d.Kind())
+	}
+}
@@ -8,8 +8,8 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
-	"syscall"
 
 	"github.com/pauek/garzon/db"
 	"github.com/pauek/garzon/eval"
@@ -22,9 +22,13 @@ type context struct {
 	dir    string // working directory
 	mode   string // current program: "model" or "accused"
 	limits Constraints
+	policy []string
 	lang   map[string]string
 	code   map[string]string
-	stderr string // the stderr written by grz-jail
+	report *jailReport // the report of grz-jail
+	uid    int         // user of the current program (0: ours, see Uids)
+
+	warnings []string // compiler warnings for the accused
 
 	State interface{}
 }
@@ -33,10 +37,15 @@ func (C *context) Dir() string     { return C.dir }
 func (C *context) ExecDir() string { return C.dir + "/eval" }
 func (C *context) Mode() string    { return C.mode }
 
-func newContext(dir string, model, accused Code, ev Evaluator) *context {
+func (C *context) PidFile() string    { return C.dir + "/" + pidFile }
+func (C *context) PolicyFile() string { return C.dir + "/.policy" }
+func (C *context) AuditFile() string  { return C.dir + "/.audit" }
+func (C *context) StderrFile() string { return C.dir + "/.stderr" }
+
+func newContext(model, accused Code, ev Evaluator) *context {
 	C := new(context)
-	C.dir = dir
 	C.limits = ev.Limits
+	C.policy = ev.Policy
 	C.lang = map[string]string{
 		"model":   model.Lang,
 		"accused": accused.Lang,
@@ -48,12 +57,26 @@ func newContext(dir string, model, accused Code, ev Evaluator) *context {
 	return C
 }
 
-func (C *context) CreateDirectory() error {
-	log.Printf("Creating directory '%s'", C.dir)
-	if err := os.RemoveAll(C.dir); err != nil {
-		return fmt.Errorf("Couldn't remove directory '%s'", C.dir)
+// CreateDirectory creates a new working directory in 'base' (only
+// accessible by the owner), with the pid of this process in '.pid'
+// (see RemoveStaleDirs).
+func (C *context) CreateDirectory(base, id string) error {
+	dir, err := ioutil.TempDir(base, dirPrefix+id+"-")
+	if err != nil {
+		return fmt.Errorf("Couldn't make a directory in '%s': %s", base, err)
+	}
+	C.dir = dir
+	log.Printf("Created directory '%s'", C.dir)
+	pid := []byte(fmt.Sprintf("%d\n", os.Getpid()))
+	if err := ioutil.WriteFile(C.PidFile(), pid, 0600); err != nil {
+		return fmt.Errorf("Couldn't write '%s': %s", C.PidFile(), err)
+	}
+	if Uids != nil { // (the user of the program needs to reach 'eval')
+		if err := os.Chmod(C.dir, 0711); err != nil {
+			return fmt.Errorf("Couldn't chmod directory '%s': %s", C.dir, err)
+		}
 	}
-	for _, subdir := range []string{"", "/.model", "/.accused", "/eval"} {
+	for _, subdir := range []string{"/.model", "/.accused", "/eval"} {
 		if err := os.Mkdir(C.dir+subdir, 0700); err != nil {
 			return fmt.Errorf("Couldn't make directory '%s'", C.dir+subdir)
 		}
@@ -62,7 +85,7 @@ func (C *context) CreateDirectory() error {
 }
 
 func (C *context) WriteAndCompile(whom string) error {
-	L := lang.ByExtension(C.lang[whom])
+	L := lang.Find(C.lang[whom])
 	if L == nil {
 		return fmt.Errorf("Unsupported language '%s'", C.lang[whom])
 	}
@@ -72,10 +95,18 @@ func (C *context) WriteAndCompile(whom string) error {
 	}
 	exefile := fmt.Sprintf("%s/.%s/exe", C.dir, whom)
 	log.Printf("Compiling '%s' ('%s')", codefile, prefix(C.code[whom], 30))
-	if err := L.Functions.Compile(codefile, exefile); err != nil {
-		os.RemoveAll(C.dir)
+	res, err := L.Functions.Compile(codefile, exefile)
+	if err == nil && !res.Success {
+		err = &lang.CompilationError{Output: res.Output, Diagnostics: res.Diagnostics}
+	}
+	if err != nil {
 		return err
 	}
+	if whom == "accused" {
+		for _, w := range res.Warnings {
+			C.warnings = append(C.warnings, w.String())
+		}
+	}
 	return nil
 }
 
@@ -89,6 +120,13 @@ func (C *context) SwitchTo(whom string) error {
 	if err := cmd.Run(); err != nil {
 		return fmt.Errorf("Couldn't copy '%s' to '%s'", from, to)
 	}
+	if whom == "accused" {
+		rules := expandPolicy(C.lang["accused"], C.policy)
+		data := strings.Join(rules, "\n") + "\n"
+		if err := ioutil.WriteFile(C.PolicyFile(), []byte(data), 0600); err != nil {
+			return fmt.Errorf("Couldn't write policy '%s': %s", C.PolicyFile(), err)
+		}
+	}
 	C.mode = whom
 	return nil
 }
@@ -101,11 +139,33 @@ func (C *context) MakeCommand() (cmd *exec.Cmd) {
 			args = append(args, fmt.Sprintf("%d", val))
 		}
 	}
+	addSeconds := func(flag string, val float64) {
+		if val > 0 {
+			args = append(args, flag)
+			args = append(args, strconv.FormatFloat(val, 'f', 3, 64))
+		}
+	}
 	addOption("-m", C.limits.Memory)
-	addOption("-t", C.limits.Time)
+	addSeconds("-t", C.limits.Time)
+	addSeconds("-w", C.limits.WallTime)
+	addSeconds("-i", C.limits.IdleTime)
 	addOption("-f", C.limits.FileSize)
+	addOption("-o", C.limits.Output)
+	args = append(args, "-json")
+	if Cgroup != "" {
+		args = append(args, "-g", Cgroup)
+		addOption("-p", C.limits.Processes)
+	}
+	if Namespaces {
+		args = append(args, "-n")
+	}
+	addOption("-u", C.uid)
 	if C.mode == "accused" {
-		args = append(args, "-a")
+		args = append(args, "-a", "-P", C.PolicyFile(), "-A", C.AuditFile())
+		args = append(args, "-e", C.StderrFile())
+		if Seccomp {
+			args = append(args, "-s")
+		}
 	}
 	args = append(args, C.dir+"/eval")
 	cmd = exec.Command(GrzJail, args...)
@@ -114,6 +174,9 @@ func (C *context) MakeCommand() (cmd *exec.Cmd) {
 }
 
 func (C *context) Destroy() error {
+	if C.dir == "" {
+		return nil // (not created)
+	}
 	if err := os.RemoveAll(C.dir); err != nil {
 		return fmt.Errorf("Couldn't remove directory '%s': %s", C.dir, err)
 	}
@@ -124,9 +187,14 @@ func (C *context) Destroy() error {
 // Evaluator //////////////////////////////////////////////////
 
 var (
-	BaseDir   string // base working directory 
-	KeepFiles bool   // keep files after evaluation (debug)
-	GrzJail   string // path of grz-jail
+	BaseDir    string  // base working directory
+	KeepFiles  bool    // keep files after evaluation (debug)
+	GrzJail    string  // path of grz-jail
+	Seccomp    bool    // run the accused with a seccomp filter (grz-jail -s)
+	Namespaces bool    // isolate programs in namespaces (grz-jail -n)
+	Cgroup     string  // parent cgroup for the programs (grz-jail -g)
+	GoJail     bool    // run programs with package jail instead of grz-jail
+	Uids       UidPool // users for the programs (grz-jail -u), if not nil
 )
 
 func init() {
@@ -135,6 +203,8 @@ func init() {
 	GrzJail = "grz-jail" // assume its in the PATH
 }
 
+// getProgram splits the solution of a problem, which has the
+// extension of the file in the first line (see ReadDir).
 func getProgram(solution string) (program Code, ok bool) {
 	i := strings.Index(solution, "\n")
 	if i == -1 {
@@ -147,72 +217,126 @@ func getProgram(solution string) (program Code, ok bool) {
 	return program, true
 }
 
-func (E Evaluator) Evaluate(P *eval.Problem, Solution string, progress chan<- string) eval.Veredict {
+func (E Evaluator) Evaluate(P *eval.Problem, Lang, Solution string, progress chan<- string) eval.Veredict {
 	E.progress = progress
 	log.Printf("Evaluate(%+v)", P.Evaluator.Obj)
 
-	// FIXME: get lang from string
 	if progress != nil {
 		progress <- "Preparing"
 	}
-	// determine language
-	code, ok := getProgram(Solution)
-	if !ok {
-		return eval.Veredict{Message: "Cannot determine language"}
+	L := lang.Find(Lang)
+	if L == nil {
+		return eval.Veredict{Message: fmt.Sprintf("Unsupported language '%s'", Lang)}
+	}
+	if !P.Allows(L.Name) {
+		return eval.Veredict{Message: fmt.Sprintf("Language '%s' not allowed", L.Name)}
+	}
+	code := Code{Lang: L.Name, Text: Solution}
+
+	// check the rules of the problem (before compiling)
+	if v := checkRules(E.Rules, code); v != nil {
+		return eval.Veredict{Message: "Rule Violation", Details: db.Obj{v}}
 	}
 
 	// prepareContext (create dirs, compile)
 	C, err := E.prepareContext(P, code)
 	if err != nil {
-		if comperr, ok := err.(*lang.CompilationError); ok {
+		switch err := err.(type) {
+		case *lang.CompilationError:
 			return eval.Veredict{
 				Message: "Compilation Error",
-				Details: db.Obj{comperr.Output},
+				Details: db.Obj{newCompilationDiagnostics(err, code.Text)},
 			}
-		} else {
-			return eval.Veredict{Message: err.Error()}
+		case *lang.CompilationLimitError:
+			return eval.Veredict{Message: err.Message}
+		case *modelError:
+			return eval.ErrorVeredict(eval.ModelError, err.err.Error())
+		default:
+			log.Printf("Cannot prepare the evaluation: %s", err)
+			return eval.ErrorVeredict(eval.InternalError, err.Error())
 		}
 	}
+	if !KeepFiles {
+		defer C.Destroy() // (even if there is a panic)
+	}
 	results := make([]TestResult, len(E.Tests))
-	ver := make(map[string]bool)
+	var diagnostics []string // (of the tests that failed to run)
+	failure := eval.InternalError
 	for i, dbobj := range E.Tests {
-		tester := dbobj.Obj.(Tester)
 		if progress != nil {
 			progress <- fmt.Sprintf("Test %d", i+1)
 		}
-		E.runTest(C, tester, &results[i])
-		ver[results[i].Veredict] = true
+		err := fmt.Errorf("Test %d is not a Tester (%T)", i+1, dbobj.Obj)
+		if tester, ok := dbobj.Obj.(Tester); ok {
+			err = E.runTest(C, tester, &results[i])
+		}
+		if err != nil {
+			log.Printf("Test %d: %s", i+1, err)
+			diagnostics = append(diagnostics, fmt.Sprintf("Test %d: %s", i+1, err))
+			if _, ok := err.(*modelFailure); ok {
+				failure = eval.JudgeError // (not the fault of the accused)
+			}
+		}
 	}
-	if !KeepFiles {
-		C.Destroy()
+	if len(diagnostics) > 0 { // (only for the administrators)
+		return eval.ErrorVeredict(failure, strings.Join(diagnostics, "\n"))
+	}
+	return eval.Veredict{
+		Message:  worstVeredict(results),
+		Details:  db.Obj{VeredictDetails{results}},
+		Warnings: C.warnings,
+	}
+}
+
+// The veredicts of the tests, from worst to best.
+var veredictPriority = []string{
+	"Execution Error", "Non-Zero Status", "Output Limit Exceeded", "Wrong Answer",
+	"Too Slow", "Accepted",
+}
+
+// worstVeredict gives the veredict of the results: the worst one, or
+// else the first which is not "Accepted" (if it is not in the list).
+func worstVeredict(results []TestResult) string {
+	ver := make(map[string]bool)
+	for _, r := range results {
+		ver[r.Veredict] = true
 	}
-	message := "<No message>"
-	for _, m := range []string{"Execution Error", "Wrong Answer", "Accepted"} {
-		if ver[m] {
-			message = m
-			break
+	for _, m := range veredictPriority {
+		if ver[m] && m != "Accepted" {
+			return m
 		}
 	}
-	return eval.Veredict{
-		Message: message,
-		Details: db.Obj{VeredictDetails{results}},
+	for _, r := range results {
+		if r.Veredict != "Accepted" {
+			return r.Veredict
+		}
+	}
+	if ver["Accepted"] {
+		return "Accepted"
 	}
+	return "<No message>"
 }
 
-func (E Evaluator) prepareContext(P *eval.Problem, accused Code) (C *context, err error) {
+func (E Evaluator) prepareContext(P *eval.Problem, accused Code) (*context, error) {
 	id := hash(accused.Text)
 	model, ok := getProgram(P.Solution)
 	if !ok {
 		return nil, fmt.Errorf("Cannot get model language")
 	}
-	C = newContext(BaseDir+"/"+id, model, accused, E)
-	if err := C.CreateDirectory(); err != nil {
+	C := newContext(model, accused, E)
+	prepared := false
+	defer func() {
+		if !prepared { // (also if there is a panic)
+			C.Destroy()
+		}
+	}()
+	if err := C.CreateDirectory(BaseDir, id[:12]); err != nil {
 		return nil, err
 	}
 	if err := C.WriteAndCompile("model"); err != nil {
 		switch err.(type) {
-		case *lang.CompilationError:
-			return nil, fmt.Errorf("Model doesn't compile!")
+		case *lang.CompilationError, *lang.CompilationLimitError:
+			return nil, &modelError{err}
 		default:
 			return nil, err
 		}
@@ -220,34 +344,106 @@ func (E Evaluator) prepareContext(P *eval.Problem, accused Code) (C *context, er
 	if err := C.WriteAndCompile("accused"); err != nil {
 		return nil, err
 	}
+	prepared = true
 	return C, nil
 }
 
+// modelError is a failure to compile the model (the problem is broken).
+type modelError struct {
+	err error
+}
+
+func (e *modelError) Error() string { return eval.ModelError }
+
+// modelFailure is a failure of the model in a test (e.g. a crash or a
+// limit exceeded), which means that the problem is broken.
+type modelFailure struct {
+	status, reason string
+}
+
+func (e *modelFailure) Error() string {
+	return fmt.Sprintf("The model failed: %s (%s)", e.status, e.reason)
+}
+
 func (E Evaluator) runTest(C *context, T Tester, R *TestResult) (err error) {
+	var model *Performance   // (if the limits derive from it)
+	var accused *Performance // (if it ran)
+	defer func() {
+		if err == nil {
+			limits := E.limits(T, C.lang["accused"], model)
+			R.Limits, R.Model, R.Performance = &limits, model, accused
+		}
+	}()
 	runtest := func(whom string) bool {
 		if err = C.SwitchTo(whom); err != nil {
 			return false
 		}
+		C.limits = E.limits(T, C.lang[whom], model)
+		if Uids != nil {
+			C.uid = Uids.Get()
+			defer func() {
+				Uids.Put(C.uid)
+				C.uid = 0
+			}()
+		}
 		cmd := C.MakeCommand()
 		if err = T.SetUp(C, cmd); err != nil {
 			return false
 		}
-		var stderr bytes.Buffer
-		cmd.Stderr = &stderr
+		if C.uid > 0 { // (including the files of the tester)
+			if err = chownTree(C.ExecDir(), C.uid); err != nil {
+				return false
+			}
+		}
 		log.Printf("Executing '%s'", whom)
-		if err = cmd.Run(); err != nil {
-			code := getExitStatus(err)
-			if code == 1 { // Execution Failed
-				err = nil
-				lines := strings.Split(stderr.String(), "\n")
-				R.Veredict = lines[0]
-				R.Reason.Obj = &SimpleReason{lines[1]}
-			} else {
-				panic("Internal error")
+		if GoJail {
+			if C.report, err = C.runGoJail(cmd); err != nil {
+				return false
+			}
+		} else {
+			var stderr bytes.Buffer
+			cmd.Stderr = &stderr
+			runerr := cmd.Run()
+			C.report, err = parseJailReport(stderr.String())
+			if err != nil {
+				if runerr != nil {
+					err = fmt.Errorf("grz-jail failed (%s): %s", runerr, err)
+				}
+				return false
+			}
+		}
+		if whom == "accused" {
+			perf := C.report.performance()
+			accused = &perf
+		}
+		switch C.report.Status {
+		case "Ok":
+			if whom == "model" && len(E.ModelLimits) > 0 {
+				perf := modelPerformance(C, T, C.report.performance())
+				model = &perf
+			}
+		case "Internal Error":
+			err = fmt.Errorf("grz-jail: %s", C.report.Message)
+			return false
+		default: // Execution Failed
+			if whom == "model" {
+				err = &modelFailure{C.report.Status, C.report.reason()}
+				return false
+			}
+			R.Veredict = C.report.Status
+			R.Reason.Obj = &SimpleReason{C.report.reason()}
+			if whom == "accused" && C.report.Forbidden != "" {
+				model := readSyscalls(C.ExecDir() + "/.syscalls")
+				R.Explanation = explainForbidden(C.report.Forbidden, model)
+				R.Trace = readTrace(C.AuditFile(), traceLines)
+			}
+			if whom == "accused" {
+				if data, err := ioutil.ReadFile(C.StderrFile()); err == nil {
+					R.Stderr = string(data)
+				}
 			}
 			return false
 		}
-		C.stderr = stderr.String()
 		if err = T.CleanUp(C); err != nil {
 			return false
 		}
@@ -265,23 +461,15 @@ func (E Evaluator) runTest(C *context, T Tester, R *TestResult) (err error) {
 	return nil
 }
 
-func getExitStatus(err error) int {
-	exiterror, ok := err.(*exec.ExitError)
-	if !ok {
-		log.Printf("Cannot get ProcessState")
-		log.Fatalf("Error was: %s\n", err)
-	}
-	status, ok := exiterror.Sys().(syscall.WaitStatus)
-	if !ok {
-		log.Fatalf("Cannot get syscall.WaitStatus")
-	}
-	return status.ExitStatus()
-}
-
 // ReadFrom reads an evaluator from a directory. It reads a text file
 // with name 'solution.*', with extension depending on the programming
 // language. Then reads all files 'test.N.<type>', where N is an integer
-// using a polymorphic method 'ReadFrom' for each tester.
+// using a polymorphic method 'ReadFrom' for each tester. An optional
+// file 'limits' has the limits of the programs (see limits.go), an
+// optional file 'languages' lists the languages in which the problem
+// can be solved, an optional file 'policy' has rules for the syscalls
+// of the accused (see policy.go), and an optional file 'rules' has
+// checks of the code of the accused (see rules.go).
 //
 func (E *Evaluator) ReadDir(dir string, prob *eval.Problem) error {
 	// Read solution
@@ -308,7 +496,26 @@ func (E *Evaluator) ReadDir(dir string, prob *eval.Problem) error {
 	prob.Solution = fmt.Sprintf("%s\n%s", ext, solstr)
 
 	// Read limits
-	E.Limits = readLimits(dir + "/limits")
+	limits, err := readLimits(dir + "/limits")
+	if err != nil {
+		return err
+	}
+	E.Limits, E.LangLimits, E.ModelLimits = limits.limits, limits.lang, limits.model
+
+	// Read allowed languages
+	if prob.Languages, err = readLanguages(dir + "/languages"); err != nil {
+		return err
+	}
+
+	// Read policy
+	if E.Policy, err = readPolicy(dir + "/policy"); err != nil {
+		return err
+	}
+
+	// Read rules
+	if E.Rules, err = readRules(dir + "/rules"); err != nil {
+		return err
+	}
 
 	// Read Tests
 	// path/filepath.glob: "New matches are added in 
@@ -334,29 +541,28 @@ func (E *Evaluator) ReadDir(dir string, prob *eval.Problem) error {
 }
 
 func getType(path string) string {
-	i := strings.LastIndex(path, ".")
-	if i == -1 {
-		panic("Assumed I would find '.' in path")
-	}
-	return path[i+1:]
+	return strings.TrimPrefix(filepath.Ext(path), ".")
 }
 
-func readLimits(path string) (lims Constraints) {
+// readLanguages reads the languages allowed in a problem, one per line
+// (by name or extension). If the file doesn't exist, all are allowed.
+func readLanguages(path string) (langs []string, err error) {
 	data, err := ioutil.ReadFile(path)
-	if err != nil {
-		return
+	if os.IsNotExist(err) {
+		return nil, nil // (all of them)
+	} else if err != nil {
+		return nil, fmt.Errorf("Cannot read '%s': %s", path, err)
 	}
-	var key string
-	var value int
 	for _, line := range strings.Split(string(data), "\n") {
-		n, _ := fmt.Sscanf(line, "%s %d", &key, &value)
-		if n == 2 {
-			switch key {
-			case "Memory": lims.Memory = value
-			case "Time": lims.Time = value
-			case "FileSize": lims.FileSize = value
-			}
+		name := strings.TrimSpace(line)
+		if name == "" {
+			continue
 		}
+		L := lang.Find(name)
+		if L == nil {
+			return nil, fmt.Errorf("Unknown language '%s' in '%s'", name, path)
+		}
+		langs = append(langs, L.Name)
 	}
-	return
+	return langs, nil
 }
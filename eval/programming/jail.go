@@ -0,0 +1,82 @@
+package programming
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/pauek/garzon/jail"
+)
+
+// jailReport is what grz-jail writes on stderr with '-json' (or what
+// package jail returns, with GoJail).
+type jailReport struct {
+	jail.Result
+}
+
+func parseJailReport(stderr string) (*jailReport, error) {
+	var report jailReport
+	if err := json.Unmarshal([]byte(strings.TrimSpace(stderr)), &report); err != nil {
+		return nil, fmt.Errorf("Cannot decode grz-jail report: %s\n%s", err, stderr)
+	}
+	if report.Status == "" {
+		return nil, fmt.Errorf("Empty grz-jail report:\n%s", stderr)
+	}
+	return &report, nil
+}
+
+// reason explains a failed execution (e.g. "Time Limit Exceeded"),
+// or gives the exit code for "Non-Zero Status".
+func (r *jailReport) reason() string {
+	if r.Status == "Non-Zero Status" {
+		return fmt.Sprintf("%d", r.ExitCode)
+	}
+	return r.Reason
+}
+
+func (r *jailReport) performance() Performance {
+	return Performance{
+		Seconds:     float32(r.CpuMs) / 1000,
+		WallSeconds: float32(r.WallMs) / 1000,
+		Megabytes:   float32(r.MemoryKb) / 1024,
+	}
+}
+
+// runGoJail runs the current program with package jail instead of
+// grz-jail, as MakeCommand would, with the input and output of cmd.
+func (C *context) runGoJail(cmd *exec.Cmd) (*jailReport, error) {
+	spec := jail.Spec{
+		Dir:      C.ExecDir(),
+		Accused:  C.mode == "accused",
+		Time:     C.limits.Time,
+		WallTime: C.limits.WallTime,
+		IdleTime: C.limits.IdleTime,
+		Memory:   C.limits.Memory,
+		FileSize: C.limits.FileSize,
+		Output:   C.limits.Output,
+		Uid:      C.uid,
+		Stdin:    cmd.Stdin,
+		Stdout:   cmd.Stdout,
+	}
+	if spec.Accused {
+		spec.Policy = expandPolicy(C.lang["accused"], C.policy)
+		stderr, err := os.Create(C.StderrFile())
+		if err != nil {
+			return nil, fmt.Errorf("Couldn't open '%s': %s", C.StderrFile(), err)
+		}
+		defer stderr.Close()
+		audit, err := os.Create(C.AuditFile())
+		if err != nil {
+			return nil, fmt.Errorf("Couldn't open '%s': %s", C.AuditFile(), err)
+		}
+		defer audit.Close()
+		spec.Stderr, spec.Audit = stderr, audit
+	}
+	R, err := jail.Run(spec)
+	if err != nil {
+		return nil, fmt.Errorf("jail: %s", err)
+	}
+	return &jailReport{R}, nil
+}
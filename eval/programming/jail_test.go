@@ -0,0 +1,124 @@
+package programming
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseJailReport(t *testing.T) {
+	report, err := parseJailReport(`{"status": "Non-Zero Status", "reason": "", "exit_code": 3, ` +
+		`"signal": 0, "cpu_ms": 1500, "wall_ms": 1600, "memory_kb": 2048, "peak_rss_kb": 900, ` +
+		`"forbidden": "", "syscalls": {"write": 2}, "message": ""}` + "\n")
+	if err != nil {
+		t.Fatalf("Cannot parse report: %s", err)
+	}
+	if report.reason() != "3" {
+		t.Errorf("Reason should be the exit code (is '%s')", report.reason())
+	}
+	if perf := report.performance(); perf.Seconds != 1.5 || perf.WallSeconds != 1.6 || perf.Megabytes != 2 {
+		t.Errorf("Wrong performance %+v", perf)
+	}
+	if report.Syscalls["write"] != 2 {
+		t.Errorf("Wrong syscall count")
+	}
+	if _, err := parseJailReport("Execution Error\nTime Limit Exceeded\n"); err == nil {
+		t.Errorf("A text report should not parse")
+	}
+}
+
+func TestExpandPolicy(t *testing.T) {
+	rules := expandPolicy("C++", []string{"profile memory", `allow open("*.txt")`})
+	n := 0
+	for _, r := range rules {
+		if r == "allow mmap(*)" {
+			n++
+		}
+		if r == "profile memory" {
+			t.Errorf("Profiles should be expanded")
+		}
+	}
+	if n != 1 {
+		t.Errorf("Profile 'memory' should be included once (is %d times)", n)
+	}
+	if rules[len(rules)-1] != `allow open("*.txt")` {
+		t.Errorf("Problem rules should go last")
+	}
+	if err := checkPolicyLine("profile Cobol"); err == nil {
+		t.Errorf("Unknown profiles should be an error")
+	}
+	if err := checkPolicyLine("permit mmap(*)"); err == nil {
+		t.Errorf("Rules should start with 'allow' or 'deny'")
+	}
+}
+
+func TestExplainForbidden(t *testing.T) {
+	model := []string{`write(1,_,_)`, `open("C")`, `open("C")`, `unlink("E")`}
+	tests := []struct{ repr, explanation string }{
+		{`open("D")`, "Your program tried to open file 'D', but only 'C' is allowed."},
+		{`creat("D")`, "Your program tried to create file 'D', but it is not allowed to create any file."},
+		{`kill(1,9)`, "Your program made the system call 'kill', which is not allowed (the solution doesn't need it)."},
+		{`i386:open("C")`, "Your program made the system call 'open' with the i386 ABI (e.g. with 'int 0x80' in a 64-bit program), which is not allowed."},
+	}
+	for _, test := range tests {
+		if e := explainForbidden(test.repr, model); e != test.explanation {
+			t.Errorf("Wrong explanation for '%s': '%s'", test.repr, e)
+		}
+	}
+}
+
+func TestReadTrace(t *testing.T) {
+	dir, err := ioutil.TempDir("", "trace")
+	if err != nil {
+		t.Fatalf("Cannot create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, ".audit")
+	var log strings.Builder
+	for i := 0; log.Len() < 2*traceBytes; i++ {
+		fmt.Fprintf(&log, "0.%03d [1] write(1,_,_) %d\n", i%1000, i)
+	}
+	if err := ioutil.WriteFile(path, []byte(log.String()), 0600); err != nil {
+		t.Fatalf("Cannot write audit log: %s", err)
+	}
+	all := strings.Split(strings.TrimRight(log.String(), "\n"), "\n")
+	trace := readTrace(path, traceLines)
+	if len(trace) != traceLines {
+		t.Fatalf("The trace should have %d lines (has %d)", traceLines, len(trace))
+	}
+	for i, line := range trace {
+		if want := all[len(all)-traceLines+i]; line != want {
+			t.Errorf("Line %d of the trace should be '%s' (is '%s')", i, want, line)
+		}
+	}
+	if trace := readTrace(path, 1<<20); len(trace) >= len(all) || trace[0] == all[0] {
+		t.Errorf("The trace should only have the end of the audit log")
+	}
+	if trace := readTrace(filepath.Join(dir, "none"), traceLines); trace != nil {
+		t.Errorf("A missing audit log should give no trace")
+	}
+}
+
+func TestReadPolicy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "policy")
+	if err != nil {
+		t.Fatalf("Cannot create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "policy")
+	if policy, err := readPolicy(path); policy != nil || err != nil {
+		t.Errorf("Without a file there should be no policy (%v, %v)", policy, err)
+	}
+	ioutil.WriteFile(path, []byte("# comment\nprofile Go\n\nallow mmap(*)\n"), 0600)
+	if policy, err := readPolicy(path); err != nil || len(policy) != 2 {
+		t.Errorf("Wrong policy %v (%v)", policy, err)
+	}
+	os.Remove(path)
+	os.Mkdir(path, 0700) // (cannot be read)
+	if _, err := readPolicy(path); err == nil {
+		t.Errorf("A file that cannot be read should be an error")
+	}
+}
@@ -0,0 +1,121 @@
+package lang
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// Compilers are run through grz-jail (in compiler mode), so that a
+// template recursion bomb or an '#include "/dev/random"' cannot hang
+// or exhaust the evaluation machine.
+
+type CompileLimits struct {
+	Time, Memory, FileSize int // seconds, bytes, bytes
+}
+
+var (
+	GrzJail string        // path of grz-jail (empty: no limits)
+	Limits  CompileLimits // limits for the compilers
+)
+
+func init() {
+	GrzJail = "grz-jail" // assume its in the PATH
+	Limits = CompileLimits{
+		Time:     30, // (a 'go build' with an empty cache takes ~10s)
+		Memory:   1024 * 1024 * 1024,
+		FileSize: 64 * 1024 * 1024,
+	}
+}
+
+type CompilationLimitError struct {
+	Message string
+}
+
+func (e *CompilationLimitError) Error() string { return e.Message }
+
+// Messages of the compilers when they get killed by the limits of
+// grz-jail (the driver, 'g++', outlives 'cc1plus').
+var limitMessages = []struct{ text, message string }{
+	{"memory exhausted", "Compilation Memory Limit Exceeded"},
+	{"Cannot allocate memory", "Compilation Memory Limit Exceeded"},
+	{"out of memory", "Compilation Memory Limit Exceeded"},
+	{"CPU time limit exceeded", "Compilation Time Limit Exceeded"},
+	{"File size limit exceeded", "Compilation Output Limit Exceeded"},
+}
+
+// compile executes a compiler command in directory 'dir' and returns
+// its output and whether it succeeded, or a CompilationLimitError if
+// it exceeds some limit.
+func compile(dir string, args ...string) (output string, ok bool, err error) {
+	if GrzJail == "" {
+		cmd := exec.Command(args[0], args[1:]...)
+		cmd.Dir = dir
+		var out bytes.Buffer
+		cmd.Stderr = &out
+		err := cmd.Run()
+		if _, exited := err.(*exec.ExitError); err != nil && !exited {
+			return "", false, fmt.Errorf("Cannot run the compiler '%s': %s", args[0], err)
+		}
+		return out.String(), err == nil, nil
+	}
+	outfile := filepath.Join(dir, ".compile")
+	defer os.Remove(outfile)
+	jailargs := []string{
+		"-c", outfile,
+		"-t", fmt.Sprintf("%d", Limits.Time),
+		"-m", fmt.Sprintf("%d", Limits.Memory),
+		"-f", fmt.Sprintf("%d", Limits.FileSize),
+		dir,
+	}
+	cmd := exec.Command(GrzJail, append(jailargs, args...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		status, ok := exitStatus(err)
+		if !ok || status != 1 {
+			return "", false, fmt.Errorf("grz-jail (compiler mode) failed: %s\n%s", err, stderr.String())
+		}
+		lines := strings.Split(stderr.String(), "\n")
+		if len(lines) > 1 && lines[0] == "Execution Error" {
+			switch lines[1] {
+			case "Time Limit Exceeded":
+				return "", false, &CompilationLimitError{"Compilation Time Limit Exceeded"}
+			case "File Size Exceeded":
+				return "", false, &CompilationLimitError{"Compilation Output Limit Exceeded"}
+			}
+		}
+	} else {
+		ok = true
+	}
+	data, err := ioutil.ReadFile(outfile)
+	if err != nil {
+		return "", false, fmt.Errorf("Cannot read compiler output '%s': %s", outfile, err)
+	}
+	output = string(data)
+	if !ok {
+		for _, lm := range limitMessages {
+			if strings.Contains(output, lm.text) {
+				return "", false, &CompilationLimitError{lm.message}
+			}
+		}
+	}
+	return output, ok, nil
+}
+
+func exitStatus(err error) (int, bool) {
+	exiterror, ok := err.(*exec.ExitError)
+	if !ok {
+		return 0, false
+	}
+	status, ok := exiterror.Sys().(syscall.WaitStatus)
+	if !ok {
+		return 0, false
+	}
+	return status.ExitStatus(), true
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"os/exec"
+	"path/filepath"
 	"strings"
 )
 
@@ -13,16 +14,12 @@ func init() {
 
 type Cpp struct{}
 
-func (L *Cpp) Compile(infile, outfile string) error {
-	cmd := exec.Command("g++", "-static", "-o", outfile, infile)
-	var out bytes.Buffer
-	cmd.Stderr = &out
-	err := cmd.Run()
+func (L *Cpp) Compile(infile, outfile string) (*Result, error) {
+	output, ok, err := compile(filepath.Dir(outfile), "g++", "-Wall", "-static", "-o", outfile, infile)
 	if err != nil {
-		output := strings.Replace(out.String(), infile, "code.cc", -1)
-		return &CompilationError{Output: output}
+		return nil, err
 	}
-	return nil
+	return newResult(ok, rename(output, infile, "code.cc"), "note"), nil
 }
 
 func (L *Cpp) Execute(filename, input string) (string, error) {
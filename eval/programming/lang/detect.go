@@ -0,0 +1,57 @@
+package lang
+
+import (
+	"bytes"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Signatures of the source code of each language, used when the
+// extension of a file doesn't determine it (e.g. '.h' or no extension).
+var signatures = []struct {
+	lang string
+	rx   *regexp.Regexp
+}{
+	{"Go", regexp.MustCompile(`(?m)^package\s+\w+\s*$`)},
+	{"C++", regexp.MustCompile(`(?m)^\s*#\s*include\s*[<"]`)},
+	{"C++", regexp.MustCompile(`(?m)^\s*using\s+namespace\s+\w+\s*;`)},
+	{"C++", regexp.MustCompile(`(?m)^\s*int\s+main\s*\(`)},
+}
+
+// Detect determines the language of a source file from its extension
+// or, if that is not possible, from a shebang line ("#!/usr/bin/env go")
+// or the contents. It returns nil if the language is unknown.
+func Detect(filename string, text []byte) *Language {
+	if L := ByExtension(filepath.Ext(filename)); L != nil {
+		return L
+	}
+	if L := shebang(text); L != nil {
+		return L
+	}
+	for _, s := range signatures {
+		if s.rx.Match(text) {
+			return ByName(s.lang)
+		}
+	}
+	return nil
+}
+
+func shebang(text []byte) *Language {
+	if !bytes.HasPrefix(text, []byte("#!")) {
+		return nil
+	}
+	line := string(text[2:])
+	if i := strings.Index(line, "\n"); i != -1 {
+		line = line[:i]
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return nil
+	}
+	interp := filepath.Base(fields[0])
+	if interp == "env" && len(fields) > 1 {
+		interp = fields[1]
+	}
+	return Find(interp)
+}
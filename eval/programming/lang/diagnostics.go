@@ -0,0 +1,58 @@
+package lang
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// A Diagnostic is an error, warning or note emitted by a compiler,
+// parsed from lines like:
+//
+//	code.cc:3:14: warning: unused variable 'x' [-Wunused-variable]
+//	./code.go:4:2: undefined: y
+type Diagnostic struct {
+	File         string
+	Line, Column int
+	Severity     string // "error", "warning" or "note"
+	Message      string
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s:%d:%d: %s: %s", d.File, d.Line, d.Column, d.Severity, d.Message)
+}
+
+var diagnosticRx = regexp.MustCompile(
+	`^(.+?):(\d+):(?:(\d+):)? (?:(fatal error|error|warning|note): )?(.*)$`)
+
+// parseDiagnostics extracts the diagnostics in the output of a
+// compiler. Lines without severity get 'implicit' (gcc uses them for
+// context, like "required from here", but go only reports errors).
+func parseDiagnostics(output, implicit string) (diags []Diagnostic) {
+	for _, line := range strings.Split(output, "\n") {
+		m := diagnosticRx.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		d := Diagnostic{File: m[1], Severity: m[4], Message: m[5]}
+		d.Line, _ = strconv.Atoi(m[2])
+		d.Column, _ = strconv.Atoi(m[3])
+		switch d.Severity {
+		case "":
+			d.Severity = implicit
+		case "fatal error":
+			d.Severity = "error"
+		}
+		diags = append(diags, d)
+	}
+	return
+}
+
+// rename hides the real path of a source file in the compiler output
+// (gcc reports the path as given, go relative to the directory).
+func rename(output, infile, name string) string {
+	output = strings.Replace(output, infile, name, -1)
+	return strings.Replace(output, "./"+filepath.Base(infile), name, -1)
+}
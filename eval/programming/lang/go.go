@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"os/exec"
+	"path/filepath"
 	"strings"
 )
 
@@ -13,16 +14,12 @@ func init() {
 
 type Go struct{}
 
-func (L *Go) Compile(infile, outfile string) error {
-	cmd := exec.Command("go", "build", "-o", outfile, infile)
-	var out bytes.Buffer
-	cmd.Stderr = &out
-	err := cmd.Run()
+func (L *Go) Compile(infile, outfile string) (*Result, error) {
+	output, ok, err := compile(filepath.Dir(outfile), "go", "build", "-o", outfile, infile)
 	if err != nil {
-		output := strings.Replace(out.String(), infile, "code.go", -1)
-		return &CompilationError{Output: output}
+		return nil, err
 	}
-	return nil
+	return newResult(ok, rename(output, infile, "code.go"), "error"), nil
 }
 
 func (L *Go) Execute(filename, input string) (string, error) {
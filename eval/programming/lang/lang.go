@@ -1,5 +1,9 @@
 package lang
 
+import (
+	"strings"
+)
+
 type Language struct {
 	Name       string
 	Extensions []string
@@ -7,12 +11,37 @@ type Language struct {
 }
 
 type Compiler interface {
-	Compile(infile, outfile string) error
+	Compile(infile, outfile string) (*Result, error)
 	Execute(exefile, input string) (string, error)
 }
 
+// Result is the outcome of a compilation. The output of the compiler
+// is parsed to obtain errors and warnings (with file, line and column).
+type Result struct {
+	Success     bool
+	Output      string
+	Warnings    []Diagnostic
+	Errors      []Diagnostic
+	Diagnostics []Diagnostic // all of them (notes too), in order
+}
+
+func newResult(success bool, output, implicit string) *Result {
+	R := &Result{Success: success, Output: output}
+	R.Diagnostics = parseDiagnostics(output, implicit)
+	for _, d := range R.Diagnostics {
+		switch d.Severity {
+		case "error":
+			R.Errors = append(R.Errors, d)
+		case "warning":
+			R.Warnings = append(R.Warnings, d)
+		}
+	}
+	return R
+}
+
 type CompilationError struct {
-	Output string
+	Output      string
+	Diagnostics []Diagnostic
 }
 
 func (e *CompilationError) Error() string { return "Compilation Error" }
@@ -40,3 +69,18 @@ func ByExtension(ext string) *Language {
 	}
 	return nil
 }
+
+// Find looks for a language by name or extension, ignoring case
+// (e.g. "C++", "c++", "cpp" or ".cc").
+func Find(name string) *Language {
+	name = strings.ToLower(name)
+	for _, L := range byName {
+		if strings.ToLower(L.Name) == name {
+			return L
+		}
+	}
+	if !strings.HasPrefix(name, ".") {
+		name = "." + name
+	}
+	return ByExtension(name)
+}
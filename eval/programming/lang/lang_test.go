@@ -0,0 +1,116 @@
+package lang
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"testing"
+)
+
+const gccOutput = `code.cc: In function 'int main()':
+code.cc:1:28: error: 'y' was not declared in this scope
+    1 | int main() { int x; return y; }
+      |                            ^
+code.cc:1:18: warning: unused variable 'x' [-Wunused-variable]
+    1 | int main() { int x; return y; }
+      |                  ^
+`
+
+const goOutput = `# command-line-arguments
+code.go:2:8: "fmt" imported and not used
+code.go:4:2: undefined: y
+`
+
+func TestGccDiagnostics(t *testing.T) {
+	R := newResult(false, gccOutput, "note")
+	if len(R.Errors) != 1 || len(R.Warnings) != 1 {
+		t.Fatalf("Wrong number of errors/warnings (%d/%d)", len(R.Errors), len(R.Warnings))
+	}
+	e := R.Errors[0]
+	if e.File != "code.cc" || e.Line != 1 || e.Column != 28 {
+		t.Errorf("Wrong position %s:%d:%d", e.File, e.Line, e.Column)
+	}
+	if e.Message != "'y' was not declared in this scope" {
+		t.Errorf("Wrong message '%s'", e.Message)
+	}
+	w := R.Warnings[0].String()
+	if w != "code.cc:1:18: warning: unused variable 'x' [-Wunused-variable]" {
+		t.Errorf("Wrong warning '%s'", w)
+	}
+}
+
+func TestGoDiagnostics(t *testing.T) {
+	R := newResult(false, goOutput, "error")
+	if len(R.Errors) != 2 || len(R.Warnings) != 0 {
+		t.Fatalf("Wrong number of errors/warnings (%d/%d)", len(R.Errors), len(R.Warnings))
+	}
+	if e := R.Errors[1]; e.Line != 4 || e.Column != 2 || e.Message != "undefined: y" {
+		t.Errorf("Wrong error '%s'", e)
+	}
+}
+
+func TestRename(t *testing.T) {
+	out := rename("./code.go:1:1: x\n/tmp/a/code.go:2:2: y", "/tmp/a/code.go", "main.go")
+	if out != "main.go:1:1: x\nmain.go:2:2: y" {
+		t.Errorf("Wrong rename: '%s'", out)
+	}
+}
+
+func TestCompileWithoutCompiler(t *testing.T) {
+	defer func(path string) { GrzJail = path }(GrzJail)
+	GrzJail = "" // (no limits)
+	if _, ok, err := compile(os.TempDir(), "no-such-compiler"); ok || err == nil {
+		t.Errorf("A compiler that cannot be run should be an error")
+	}
+	if _, ok, err := compile(os.TempDir(), "false"); ok || err != nil {
+		t.Errorf("A compiler that fails should give ok == false (%v)", err)
+	}
+}
+
+func TestCompileLimits(t *testing.T) {
+	if _, err := exec.LookPath(GrzJail); err != nil {
+		t.Skipf("No '%s'", GrzJail)
+	}
+	dir, err := ioutil.TempDir("", "compile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(limits CompileLimits) { Limits = limits }(Limits)
+	Limits = CompileLimits{Time: 1, Memory: 256 * 1024 * 1024, FileSize: 1024 * 1024}
+	tests := []struct{ command, message string }{
+		{"while :; do :; done", "Compilation Time Limit Exceeded"},
+		{"yes > big", "Compilation Output Limit Exceeded"},
+	}
+	for _, test := range tests {
+		_, ok, err := compile(dir, "sh", "-c", test.command)
+		if e, isLimit := err.(*CompilationLimitError); ok || !isLimit || e.Message != test.message {
+			t.Errorf("'%s' should give '%s' (ok %v, error %v)", test.command, test.message, ok, err)
+		}
+	}
+	if out, ok, err := compile(dir, "sh", "-c", "echo fine >&2"); !ok || err != nil || out != "fine\n" {
+		t.Errorf("A compiler within the limits should work ('%s', ok %v, error %v)", out, ok, err)
+	}
+}
+
+func TestDetect(t *testing.T) {
+	cases := []struct{ filename, text, lang string }{
+		{"sum.cc", "", "C++"},
+		{"sum.go", "", "Go"},
+		{"sum.h", "#include <iostream>\nint f();\n", "C++"},
+		{"sum", "package main\n\nfunc main() {}\n", "Go"},
+		{"sum", "#!/usr/bin/env go\npackage main\n", "Go"},
+		{"sum", "int main() {}\n", "C++"},
+		{"sum.txt", "hello\n", ""},
+	}
+	for _, c := range cases {
+		L := Detect(c.filename, []byte(c.text))
+		name := ""
+		if L != nil {
+			name = L.Name
+		}
+		if name != c.lang {
+			t.Errorf("Detect('%s') = '%s' (should be '%s')", c.filename, name, c.lang)
+		}
+	}
+}
@@ -0,0 +1,317 @@
+package programming
+
+import (
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pauek/garzon/eval/programming/lang"
+)
+
+// Limits of the programs
+//
+// The file 'limits' of a problem has one limit per line, with the name
+// of a field of Constraints and its value:
+//
+//   Time 1
+//   Memory 268435456
+//
+// A line can also adjust a limit for the programs in a language, with
+// a factor ('x') or an offset ('+'), applied in order:
+//
+//   Go Time x3
+//   Go Memory +100000000
+//
+// The time and memory limits can also derive from the performance of
+// the model in each test, with a factor and an offset:
+//
+//   Time model x3 +0.5
+//   Memory model x2
+//
+// A test directory (e.g. of a FilesTester) can have its own 'limits'
+// file, which overrides the limits of the problem for that test. The
+// limits derived from the model replace those, and the adjustments for
+// the language are applied last. A limit can only be adjusted for a
+// language if the file sets it (grz-jail's defaults are not known).
+
+// A LangLimit adjusts a limit for the programs in a language: the
+// limit becomes Limit*Factor + Offset.
+type LangLimit struct {
+	Lang   string // name of the language
+	Limit  string // name of the limit (e.g. "Time")
+	Factor float64
+	Offset float64
+}
+
+// A ModelLimit derives a limit from the performance of the model in a
+// test: the limit is Factor*(model's) + Offset.
+type ModelLimit struct {
+	Limit  string // "Time" (seconds) or "Memory" (bytes)
+	Factor float64
+	Offset float64
+}
+
+func (m ModelLimit) apply(c *Constraints, model Performance) {
+	switch m.Limit {
+	case "Time":
+		c.Time = m.Factor*float64(model.Seconds) + m.Offset
+	case "Memory":
+		c.Memory = int(m.Factor*float64(model.Megabytes)*1024*1024 + m.Offset)
+	}
+}
+
+// A Limiter is a Tester which can have its own limits (nil if not).
+type Limiter interface {
+	TestLimits() *Constraints
+}
+
+var limitNames = []string{
+	"Memory", "Time", "WallTime", "IdleTime", "FileSize", "Output", "Processes",
+}
+
+func (c *Constraints) get(name string) (value float64, ok bool) {
+	switch name {
+	case "Memory":
+		return float64(c.Memory), true
+	case "Time":
+		return c.Time, true
+	case "WallTime":
+		return c.WallTime, true
+	case "IdleTime":
+		return c.IdleTime, true
+	case "FileSize":
+		return float64(c.FileSize), true
+	case "Output":
+		return float64(c.Output), true
+	case "Processes":
+		return float64(c.Processes), true
+	}
+	return 0, false
+}
+
+func (c *Constraints) set(name string, value float64) bool {
+	switch name {
+	case "Memory":
+		c.Memory = int(value)
+	case "Time":
+		c.Time = value
+	case "WallTime":
+		c.WallTime = value
+	case "IdleTime":
+		c.IdleTime = value
+	case "FileSize":
+		c.FileSize = int(value)
+	case "Output":
+		c.Output = int(value)
+	case "Processes":
+		c.Processes = int(value)
+	default:
+		return false
+	}
+	return true
+}
+
+// override sets the limits which are set in o.
+func (c *Constraints) override(o Constraints) {
+	for _, name := range limitNames {
+		if value, _ := o.get(name); value > 0 {
+			c.set(name, value)
+		}
+	}
+}
+
+// forLang adjusts the limits for a language.
+func (c Constraints) forLang(name string, adjust []LangLimit) Constraints {
+	for _, a := range adjust {
+		if a.Lang != name {
+			continue
+		}
+		if value, _ := c.get(a.Limit); value > 0 {
+			c.set(a.Limit, value*a.Factor+a.Offset)
+		}
+	}
+	return c
+}
+
+// limits gives the limits of a program in a language for test T. If
+// model is not nil, it is the performance of the model in the test.
+func (E Evaluator) limits(T Tester, lang string, model *Performance) Constraints {
+	lims := E.Limits
+	if L, ok := T.(Limiter); ok && L.TestLimits() != nil {
+		lims.override(*L.TestLimits())
+	}
+	if model != nil {
+		for _, m := range E.ModelLimits {
+			m.apply(&lims, *model)
+		}
+	}
+	return lims.forLang(lang, E.LangLimits)
+}
+
+// modelCache keeps the performance of the model in each test, so that
+// the limits derived from it are the same in all the evaluations. It
+// keeps only the modelCacheSize most recently used tests.
+var modelCache = newPerfCache(modelCacheSize)
+
+const modelCacheSize = 4096
+
+// A perfCache is a cache of performances with LRU eviction.
+type perfCache struct {
+	sync.Mutex
+	size  int
+	perf  map[string]*list.Element // (of the cacheEntry)
+	order *list.List               // most recently used first
+}
+
+type cacheEntry struct {
+	key  string
+	perf Performance
+}
+
+func newPerfCache(size int) *perfCache {
+	return &perfCache{size: size, perf: make(map[string]*list.Element), order: list.New()}
+}
+
+// get gives the cached performance for key, or caches perf if there is
+// none (evicting the least recently used if it is full).
+func (c *perfCache) get(key string, perf Performance) Performance {
+	c.Lock()
+	defer c.Unlock()
+	if e, ok := c.perf[key]; ok {
+		c.order.MoveToFront(e)
+		return e.Value.(*cacheEntry).perf
+	}
+	c.perf[key] = c.order.PushFront(&cacheEntry{key, perf})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.perf, oldest.Value.(*cacheEntry).key)
+	}
+	return perf
+}
+
+// modelPerformance gives the cached performance of the model in test T
+// (perf if there was none).
+func modelPerformance(C *context, T Tester, perf Performance) Performance {
+	data, _ := json.Marshal(T)
+	key := hash(C.lang["model"] + "\n" + C.code["model"] + "\n" + string(data))
+	return modelCache.get(key, perf)
+}
+
+// parseAdjustment parses a factor ("x3" or "*3") or an offset ("+0.5").
+func parseAdjustment(field string, factor, offset *float64) error {
+	op, num := field[:1], field[1:]
+	value, err := strconv.ParseFloat(num, 64)
+	if err != nil || value < 0 {
+		return fmt.Errorf("Wrong number '%s'", num)
+	}
+	switch op {
+	case "x", "*":
+		*factor = value
+	case "+":
+		*offset = value
+	default:
+		return fmt.Errorf("Expected 'x' or '+' (not '%s')", op)
+	}
+	return nil
+}
+
+// parseLangLimit parses the fields of an adjustment for a language
+// (e.g. "Go", "Time", "x3").
+func parseLangLimit(fields []string) (a LangLimit, err error) {
+	L := lang.Find(fields[0])
+	if L == nil {
+		return a, fmt.Errorf("Unknown language '%s'", fields[0])
+	}
+	var c Constraints
+	if _, ok := c.get(fields[1]); !ok {
+		return a, fmt.Errorf("Unknown limit '%s'", fields[1])
+	}
+	a = LangLimit{Lang: L.Name, Limit: fields[1], Factor: 1}
+	err = parseAdjustment(fields[2], &a.Factor, &a.Offset)
+	return a, err
+}
+
+// parseModelLimit parses the fields of a limit derived from the model
+// (e.g. "Time", "model", "x3", "+0.5").
+func parseModelLimit(fields []string) (m ModelLimit, err error) {
+	if fields[0] != "Time" && fields[0] != "Memory" {
+		return m, fmt.Errorf("Only 'Time' and 'Memory' derive from the model")
+	}
+	m = ModelLimit{Limit: fields[0], Factor: 1}
+	for _, field := range fields[2:] {
+		if err := parseAdjustment(field, &m.Factor, &m.Offset); err != nil {
+			return m, err
+		}
+	}
+	return m, nil
+}
+
+// A limitsFile has the contents of a 'limits' file.
+type limitsFile struct {
+	limits Constraints
+	lang   []LangLimit
+	model  []ModelLimit
+}
+
+// readLimits reads a 'limits' file (no limits if it doesn't exist).
+func readLimits(path string) (L limitsFile, err error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return L, nil
+	} else if err != nil {
+		return L, fmt.Errorf("Cannot read '%s': %s", path, err)
+	}
+	var langLines []int // (of each adjustment)
+	for i, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) == 0:
+		case len(fields) >= 2 && fields[1] == "model":
+			m, err := parseModelLimit(fields)
+			if err != nil {
+				return L, fmt.Errorf("%s:%d: %s", path, i+1, err)
+			}
+			L.model = append(L.model, m)
+		case len(fields) == 2:
+			value, err := strconv.ParseFloat(fields[1], 64)
+			if err != nil || value < 0 {
+				return L, fmt.Errorf("%s:%d: Wrong number '%s'", path, i+1, fields[1])
+			}
+			if !L.limits.set(fields[0], value) {
+				return L, fmt.Errorf("%s:%d: Unknown limit '%s'", path, i+1, fields[0])
+			}
+		case len(fields) == 3:
+			a, err := parseLangLimit(fields)
+			if err != nil {
+				return L, fmt.Errorf("%s:%d: %s", path, i+1, err)
+			}
+			L.lang = append(L.lang, a)
+			langLines = append(langLines, i+1)
+		default:
+			return L, fmt.Errorf("%s:%d: Wrong limit '%s'", path, i+1, strings.TrimSpace(line))
+		}
+	}
+	for i, a := range L.lang {
+		if !L.sets(a.Limit) {
+			return L, fmt.Errorf("%s:%d: Limit '%s' is adjusted but not set", path, langLines[i], a.Limit)
+		}
+	}
+	return L, nil
+}
+
+// sets tells if a limits file sets a limit (or derives it from the model).
+func (L limitsFile) sets(limit string) bool {
+	for _, m := range L.model {
+		if m.Limit == limit {
+			return true
+		}
+	}
+	value, _ := L.limits.get(limit)
+	return value > 0
+}
@@ -0,0 +1,152 @@
+package programming
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// A policy complements the syscalls of the model for the accused
+// (see grz-jail, option -P), with lines like 'allow mmap(*)' or
+// 'deny open("*passwd*")'. In the 'policy' file of a problem, a line
+// 'profile <name>' includes a whole profile. Patterns are matched like
+// paths in the shell ('*' doesn't match '/'), and no 'allow' rule
+// allows a filename with '..' or through /proc/*/root or /proc/*/cwd.
+
+// Profiles are named base policies. The profile with the name of the
+// language of the accused is always included, since the runtime of a
+// language (or a different version of the library) can make syscalls
+// that the model didn't.
+var Profiles = map[string][]string{
+	"memory": {
+		"allow brk(*)",
+		"allow mmap(*)",
+		"allow munmap(*)",
+		"allow mremap(*)",
+		"allow mprotect(*)",
+		"allow madvise(*)",
+	},
+	"C++": {
+		"profile memory",
+		"allow fstat(*)",
+		`allow newfstatat(*,"")`,
+		"allow ioctl(*,21505)", // TCGETS (isatty)
+		"allow arch_prctl(*)",
+		"allow set_tid_address(*)",
+		"allow set_robust_list(*)",
+		"allow rseq(*)",
+		"allow prlimit64(*)",
+		"allow getrandom(*)",
+		`allow readlink("/proc/self/exe")`,
+		"allow rt_sigprocmask(*)",
+		"allow rt_sigaction(*)",
+		"allow futex(*)",
+		"allow exit(*)",
+		"allow exit_group(*)",
+	},
+	"Go": {
+		"profile memory",
+		"allow arch_prctl(*)",
+		"allow sched_getaffinity(*)",
+		"allow sched_yield()",
+		"allow rt_sigprocmask(*)",
+		"allow rt_sigaction(*)",
+		"allow rt_sigreturn()",
+		"allow sigaltstack(*)",
+		"allow clone(*)",
+		"allow clone3(*)",
+		"allow futex(*)",
+		"allow nanosleep(*)",
+		"allow gettid()",
+		"allow getpid()",
+		"allow tgkill(*)",
+		"allow prctl(*)",
+		"allow fcntl(*)",
+		"allow prlimit64(*)",
+		"allow epoll_create1(*)",
+		"allow epoll_ctl(*)",
+		"allow epoll_pwait(*)",
+		"allow eventfd2(*)",
+		"allow pipe2(*)",
+		// files read by the runtime (its CPU quota, with grz-jail -g,
+		// is allowed by grz-jail itself)
+		`allow openat(*,"/sys/kernel/mm/transparent_hugepage/hpage_pmd_size")`,
+		`allow openat(*,"/proc/self/cgroup")`,
+		`allow openat(*,"/proc/self/mountinfo")`,
+		`allow openat(*,"/proc/self/maps")`,
+		`allow openat(*,"/sys/fs/cgroup/cpu.max")`,
+		`allow openat(*,"/sys/fs/cgroup/cpu/cpu.cfs_quota_us")`,
+		`allow openat(*,"/sys/fs/cgroup/cpu/cpu.cfs_period_us")`,
+		"allow exit(*)",
+		"allow exit_group(*)",
+	},
+}
+
+// checkPolicyLine checks that a line is a rule, a profile, or empty
+// (or a comment).
+func checkPolicyLine(line string) error {
+	fields := strings.Fields(line)
+	if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+		return nil
+	}
+	switch fields[0] {
+	case "allow", "deny":
+		if len(fields) < 2 {
+			return fmt.Errorf("Missing pattern in '%s'", line)
+		}
+	case "profile":
+		if len(fields) != 2 {
+			return fmt.Errorf("Wrong profile line '%s'", line)
+		}
+		if _, ok := Profiles[fields[1]]; !ok {
+			return fmt.Errorf("Unknown profile '%s'", fields[1])
+		}
+	default:
+		return fmt.Errorf("Wrong policy line '%s'", line)
+	}
+	return nil
+}
+
+// readPolicy reads the 'policy' file of a problem (if it exists).
+func readPolicy(path string) (policy []string, err error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("Cannot read '%s': %s", path, err)
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if err := checkPolicyLine(line); err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+		if line != "" && !strings.HasPrefix(line, "#") {
+			policy = append(policy, line)
+		}
+	}
+	return policy, nil
+}
+
+// expandPolicy returns the rules of the profile for 'lang' and
+// 'policy', including profiles only once.
+func expandPolicy(lang string, policy []string) (rules []string) {
+	included := make(map[string]bool)
+	var expand func(lines []string)
+	expand = func(lines []string) {
+		for _, line := range lines {
+			fields := strings.Fields(line)
+			if len(fields) == 2 && fields[0] == "profile" {
+				if !included[fields[1]] {
+					included[fields[1]] = true
+					expand(Profiles[fields[1]])
+				}
+				continue
+			}
+			rules = append(rules, line)
+		}
+	}
+	expand([]string{"profile " + lang})
+	expand(policy)
+	return
+}
@@ -9,9 +9,13 @@ import (
 )
 
 type Evaluator struct {
-	Limits   Constraints
-	Tests    []db.Obj
-	progress chan<- string
+	Limits      Constraints
+	LangLimits  []LangLimit  `json:",omitempty"` // see limits.go
+	ModelLimits []ModelLimit `json:",omitempty"`
+	Tests       []db.Obj
+	Policy      []string `json:",omitempty"` // see policy.go
+	Rules       []Rule   `json:",omitempty"` // see rules.go
+	progress    chan<- string
 }
 
 type Code struct {
@@ -19,7 +23,11 @@ type Code struct {
 }
 
 type Constraints struct {
-	Memory, Time, FileSize int
+	Memory, FileSize int     // bytes
+	Output           int     // bytes of standard output
+	Processes        int     // max processes and threads (with a cgroup)
+	Time, WallTime   float64 // seconds (CPU and wall clock)
+	IdleTime         float64 // seconds without using the CPU
 }
 
 type Tester interface {
@@ -42,12 +50,87 @@ func (vd VeredictDetails) String() string {
 	for i, r := range vd.Results {
 		fmt.Fprintf(&b, "%d. %s\n", i+1, r)
 	}
+	b.WriteString(vd.Table())
+	return b.String()
+}
+
+// Max gives the maximum time and memory of the accused in the tests.
+func (vd VeredictDetails) Max() (max Performance) {
+	for _, r := range vd.Results {
+		if p := r.Performance; p != nil {
+			if p.Seconds > max.Seconds {
+				max.Seconds = p.Seconds
+			}
+			if p.WallSeconds > max.WallSeconds {
+				max.WallSeconds = p.WallSeconds
+			}
+			if p.Megabytes > max.Megabytes {
+				max.Megabytes = p.Megabytes
+			}
+		}
+	}
+	return
+}
+
+// Total gives the sum of the time and memory of the accused in the
+// tests.
+func (vd VeredictDetails) Total() (total Performance) {
+	for _, r := range vd.Results {
+		if p := r.Performance; p != nil {
+			total.Seconds += p.Seconds
+			total.WallSeconds += p.WallSeconds
+			total.Megabytes += p.Megabytes
+		}
+	}
+	return
+}
+
+// Table shows the performance of the accused in each test, with the
+// limits, and the maximum and total ("" if it didn't run).
+func (vd VeredictDetails) Table() string {
+	var b bytes.Buffer
+	limit := func(format string, value float64) string {
+		if value <= 0 {
+			return "-" // (the default of grz-jail)
+		}
+		return fmt.Sprintf(format, value)
+	}
+	row := func(test string, p Performance, time, memory string) {
+		line := fmt.Sprintf("%6s %9.3f %9s %9.3f %9.1f %9s",
+			test, p.Seconds, time, p.WallSeconds, p.Megabytes, memory)
+		fmt.Fprintf(&b, "%s\n", strings.TrimRight(line, " "))
+	}
+	for i, r := range vd.Results {
+		if r.Performance == nil {
+			continue
+		}
+		if b.Len() == 0 {
+			fmt.Fprintf(&b, "%6s %9s %9s %9s %9s %9s\n",
+				"Test", "CPU (s)", "Limit", "Wall (s)", "Mem (MB)", "Limit")
+		}
+		time, memory := "-", "-"
+		if L := r.Limits; L != nil {
+			time = limit("%.3f", L.Time)
+			memory = limit("%.1f", float64(L.Memory)/(1024*1024))
+		}
+		row(fmt.Sprintf("%d", i+1), *r.Performance, time, memory)
+	}
+	if b.Len() > 0 {
+		row("Max", vd.Max(), "", "")
+		row("Total", vd.Total(), "", "")
+	}
 	return b.String()
 }
 
 type TestResult struct {
-	Veredict string
-	Reason   db.Obj
+	Veredict    string
+	Reason      db.Obj
+	Explanation string       `json:",omitempty"` // of a forbidden syscall
+	Trace       []string     `json:",omitempty"` // last syscalls (see audit.go)
+	Stderr      string       `json:",omitempty"` // of the accused (when it fails)
+	Limits      *Constraints `json:",omitempty"` // of the accused
+	Model       *Performance `json:",omitempty"` // if the limits derive from it
+	Performance *Performance `json:",omitempty"` // of the accused
 }
 
 func (T *TestResult) GoodVsBad() (ok bool) {
@@ -64,15 +147,37 @@ func (tr TestResult) String() string {
 		} else {
 			fmt.Fprintf(&b, "\n")
 		}
+		if tr.Explanation != "" {
+			fmt.Fprintf(&b, "%s\n", tr.Explanation)
+		}
+		if len(tr.Trace) > 0 {
+			fmt.Fprintf(&b, "Last syscalls:\n")
+			for _, line := range tr.Trace {
+				fmt.Fprintf(&b, "   %s\n", line)
+			}
+		}
+		if tr.Stderr != "" {
+			fmt.Fprintf(&b, "Standard error:\n%s\n", tr.Stderr)
+		}
+		if tr.Model != nil && tr.Limits != nil {
+			fmt.Fprintf(&b, "Limits: %.3f sec, %.1f MB (the model: %.3f sec, %.1f MB)\n",
+				tr.Limits.Time, float64(tr.Limits.Memory)/(1024*1024),
+				tr.Model.Seconds, tr.Model.Megabytes)
+		}
 	} else {
-		fmt.Fprintf(&b, " [%+v]\n", tr.Reason)
+		fmt.Fprintf(&b, "\n") // (see VeredictDetails.Table)
 	}
 	return b.String()
 }
 
 type Performance struct {
-	Seconds float32
-	Megabytes float32
+	Seconds     float32 // of CPU
+	WallSeconds float32 `json:",omitempty"`
+	Megabytes   float32 // peak memory
+}
+
+func (p Performance) String() string {
+	return fmt.Sprintf("%.3f sec, %.1f MB", p.Seconds, p.Megabytes)
 }
 
 type SimpleReason struct {
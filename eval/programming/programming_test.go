@@ -3,11 +3,14 @@ package programming
 
 import (
 	"os"
+	"os/exec"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"strings"
 	"regexp"
 	"testing"
+	"time"
 
 	"github.com/pauek/garzon/db"
 	"github.com/pauek/garzon/eval"
@@ -40,7 +43,14 @@ func evalWithInputs(model, accused string, I []string) eval.Veredict {
       Solution: model, // FIXME: Code{Lang: "c++", Text: model},
 		Evaluator: db.Obj{ev},
 	}
-	return ev.Evaluate(prob, accused, nil)
+	return evaluate(ev, prob, accused)
+}
+
+// evaluate submits a solution with the extension in the first line
+// (like the constants below).
+func evaluate(ev *Evaluator, prob *eval.Problem, solution string) eval.Veredict {
+	code, _ := getProgram(solution)
+	return ev.Evaluate(prob, code.Lang, code.Text, nil)
 }
 
 const Minimal = `.cc
@@ -84,6 +94,9 @@ func TestModelDoesntCompile(t *testing.T) {
 	if V.Message != "Model doesn't compile!" {
 		t.Errorf(`Error is not "Model doesn't compile" (is '%s')"`, V.Message)
 	}
+	if !V.IsError() {
+		t.Errorf("A model that doesn't compile should be an error of the judge")
+	}
 }
 
 func TestAccusedDoesntCompile(t *testing.T) {
@@ -179,8 +192,8 @@ func TestMemoryLimit2(t *testing.T) {
 
 int main() {
    int i;
-   void *data[64];
-   for (i = 0; i < 64; i++) {
+   void *data[32]; // (under the 64 MB limit)
+   for (i = 0; i < 32; i++) {
       data[i] = malloc(1024 * 1024); // 1 MB
 	}
 }
@@ -199,14 +212,53 @@ int main() {
 	testExecutionError(t, normal, wrong, "Memory Limit Exceeded")   
 }
 
-// TODO: Aborted
-// TODO: Interrupted
+func TestOutputLimitExceeded(t *testing.T) {
+	oneX := ".cc\n#include <iostream>\nint main() { std::cout << 'x'; }"
+	infOutput := ".cc\n#include <iostream>\nint main() { while (1) std::cout << 'x'; }"
+	ev := &Evaluator{
+		Limits: Constraints{Output: 1024 * 1024},
+		Tests:  []db.Obj{db.Obj{&InputTester{Input: ""}}},
+	}
+	prob := &eval.Problem{Solution: oneX, Evaluator: db.Obj{ev}}
+	R0 := results(evaluate(ev, prob, infOutput))[0]
+	if R0.Veredict != "Output Limit Exceeded" {
+		t.Errorf("Should be 'Output Limit Exceeded' (is '%s')", R0.Veredict)
+	}
+}
+
+// testSignaled checks the veredict of an accused that raises a
+// signal (with a policy, since the model can't do it).
+func testSignaled(t *testing.T, accused, expected string) {
+	ev := &Evaluator{
+		Tests:  []db.Obj{db.Obj{&InputTester{Input: ""}}},
+		Policy: []string{"allow getpid()", "allow gettid()", "allow tgkill(*)"},
+	}
+	prob := &eval.Problem{Solution: Minimal, Evaluator: db.Obj{ev}}
+	R0 := results(evaluate(ev, prob, accused))[0]
+	if R0.Veredict != "Execution Error" {
+		t.Errorf("Should be 'Execution Error' (is '%s')", R0)
+	}
+	if reason, ok := R0.Reason.Obj.(*SimpleReason); !ok || reason.Message != expected {
+		t.Errorf("Wrong veredict \"%s\", should be \"%s\"", R0, expected)
+	}
+}
+
+func TestAborted(t *testing.T) {
+	aborter := ".cc\n#include <cstdlib>\nint main() { abort(); }"
+	testSignaled(t, aborter, "Aborted")
+}
+
+func TestInterrupted(t *testing.T) {
+	interrupted := ".cc\n#include <csignal>\nint main() { raise(SIGINT); }"
+	testSignaled(t, interrupted, "Interrupted")
+}
 
 func TestForbiddenSyscall1(t *testing.T) {
 	opener := `.cc
 #include <fstream>
 int main() { std::ofstream F("file"); F << '\n'; }`
-	testExecutionError(t, Minimal, opener, `Forbidden Syscall 'open\("file"\)'`)
+	// (modern libcs use 'openat', with AT_FDCWD as the directory)
+	testExecutionError(t, Minimal, opener, `Forbidden Syscall '(open\(|openat\([0-9]+,)"file"\)'`)
 }
 
 func TestForbiddenSyscall(t *testing.T) {
@@ -217,7 +269,7 @@ int main() {
    execve("/bin/ls", argv, envp); 
 }`
 	testExecutionError(t, Minimal, execer, 
-		`Forbidden Syscall '_execve\([0-9a-f]*,[0-9a-f]*,[0-9a-f]*\)'`)
+		`Forbidden Syscall 'execve\([0-9a-f]*,[0-9a-f]*,[0-9a-f]*\)'`)
 }
 
 // TODO: Forbidden Syscall (fork) ?
@@ -287,13 +339,13 @@ func TestFileTester(t *testing.T) {
 	var V eval.Veredict
 
 	// Good
-	V = filesEv.Evaluate(filesProb, sumABFiles, nil)
+	V = evaluate(filesEv, filesProb, sumABFiles)
 	if firstRes(V) != "Accepted" {
 		t.Errorf("Test should be accepted")
 	}
 
 	// Creates a file named 'D' instead of 'C'
-	V = filesEv.Evaluate(filesProb, wrongFiles1, nil)
+	V = evaluate(filesEv, filesProb, wrongFiles1)
 	R0 := results(V)[0]
 	if R0.Veredict != "Execution Error" {
 		t.Errorf("Should be 'Execution Error'")
@@ -302,13 +354,434 @@ func TestFileTester(t *testing.T) {
 	if !ok {
 		t.Errorf("Reason is no a SimpleReason")
 	}
-	if reason.Message != "Forbidden Syscall 'open(\"D\")'" {
-		t.Errorf("Wrong Veredict")
+	forbiddenD := `^Forbidden Syscall '(open\(|openat\([0-9]+,)"D"\)'`
+	if ok, _ := regexp.MatchString(forbiddenD, reason.Message); !ok {
+		t.Errorf("Wrong Veredict (%s)", reason.Message)
 	}
 
 	// Doesn't compute sum
-	V = filesEv.Evaluate(filesProb, wrongAnswer1, nil)
+	V = evaluate(filesEv, filesProb, wrongAnswer1)
 	if res := firstRes(V); res != "Wrong Answer" {
 		t.Errorf("Wrong Veredict ('%s')", res)
 	}
 }
+
+func TestWorkingDirectories(t *testing.T) {
+	code := Code{Lang: "C++", Text: "int main() {}"}
+	A, B := newContext(code, code, Evaluator{}), newContext(code, code, Evaluator{})
+	id := hash(code.Text)[:12]
+	if err := A.CreateDirectory(BaseDir, id); err != nil {
+		t.Fatalf("Cannot create directory: %s", err)
+	}
+	defer A.Destroy()
+	if err := B.CreateDirectory(BaseDir, id); err != nil {
+		t.Fatalf("Cannot create directory: %s", err)
+	}
+	defer B.Destroy()
+	if A.Dir() == B.Dir() {
+		t.Errorf("Identical submissions should have different directories")
+	}
+	if info, err := os.Stat(A.Dir()); err != nil || info.Mode().Perm() != 0700 {
+		t.Errorf("The directory should be accessible only by the owner")
+	}
+
+	// A directory of a process that doesn't exist is stale
+	dead := exec.Command("true")
+	if err := dead.Run(); err != nil {
+		t.Fatalf("Cannot run 'true': %s", err)
+	}
+	pid := fmt.Sprintf("%d\n", dead.Process.Pid)
+	if err := ioutil.WriteFile(B.PidFile(), []byte(pid), 0600); err != nil {
+		t.Fatalf("Cannot write '%s': %s", B.PidFile(), err)
+	}
+	// ... but not a directory without '.pid' (e.g. '~/grz-jail'), even if old
+	other, err := ioutil.TempDir(BaseDir, dirPrefix+"other-")
+	if err != nil {
+		t.Fatalf("Cannot create directory: %s", err)
+	}
+	defer os.RemoveAll(other)
+	old := time.Now().Add(-24 * time.Hour)
+	os.Chtimes(other, old, old)
+	if n, err := RemoveStaleDirs(); err != nil || n != 1 {
+		t.Errorf("Should remove 1 stale directory (removed %d, %v)", n, err)
+	}
+	if _, err := os.Stat(A.Dir()); err != nil {
+		t.Errorf("The directory of this process should not be removed")
+	}
+	if _, err := os.Stat(B.Dir()); !os.IsNotExist(err) {
+		t.Errorf("The stale directory should be removed")
+	}
+	if _, err := os.Stat(other); err != nil {
+		t.Errorf("A directory without '.pid' should not be removed")
+	}
+}
+
+func TestUids(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("Only root can run programs as other users")
+	}
+	if err := os.Chmod(BaseDir, 0711); err != nil {
+		t.Fatalf("Cannot chmod '%s': %s", BaseDir, err)
+	}
+	Uids = NewUidPool(20000, 20001)
+	defer func() { Uids = nil }()
+	V := evalWithInputs(SumAB, SumAB, []string{"2 3\n", "4 5\n"})
+	if V.Message != "Accepted" {
+		t.Errorf("Should be 'Accepted' (is '%s'):\n%v", V.Message, V.Details.Obj)
+	}
+	if len(Uids) != 2 {
+		t.Errorf("The uids should be back in the pool")
+	}
+}
+
+// submit runs eval.Submit and waits for "Resolved".
+func submit(S eval.Submission) eval.Veredict {
+	var V eval.Veredict
+	progress := make(chan string)
+	go eval.Submit(S, &V, progress)
+	for msg := range progress {
+		if msg == "Resolved" {
+			break
+		}
+	}
+	return V
+}
+
+type panicTester struct{ InputTester }
+
+func (panicTester) Prepare(C *context) { panic("broken tester") }
+
+func TestJudgeErrors(t *testing.T) {
+	code, _ := getProgram(Minimal)
+	problem := func(tests ...db.Obj) *eval.Problem {
+		return &eval.Problem{Solution: Minimal, Evaluator: db.Obj{&Evaluator{Tests: tests}}}
+	}
+	brokenModel := problem(db.Obj{&InputTester{}})
+	brokenModel.Solution = ".cc\nint main() { return 1; }"
+	tests := []struct {
+		what    string
+		problem *eval.Problem
+		message string
+	}{
+		{"No problem", nil, eval.JudgeError},
+		{"Wrong evaluator", &eval.Problem{Evaluator: db.Obj{"none"}}, eval.JudgeError},
+		{"Panic", problem(db.Obj{&panicTester{}}), eval.JudgeError},
+		{"Not a tester", problem(db.Obj{"none"}), eval.InternalError},
+		{"Model fails", brokenModel, eval.JudgeError},
+	}
+	for _, test := range tests {
+		V := submit(eval.Submission{Problem: test.problem, Lang: code.Lang, Solution: code.Text})
+		if V.Message != test.message || !V.IsError() {
+			t.Errorf("%s: should be '%s' (is '%s')", test.what, test.message, V.Message)
+		}
+	}
+	V := submit(eval.Submission{Problem: problem(db.Obj{&panicTester{}}), Lang: code.Lang, Solution: code.Text})
+	if details, ok := V.Details.Obj.(*eval.ErrorDetails); !ok || !strings.Contains(details.Diagnostics, "broken tester") {
+		t.Errorf("The diagnostics should have the panic (are %v)", V.Details.Obj)
+	}
+	V = submit(eval.Submission{Problem: problem(db.Obj{"none"}), Lang: code.Lang, Solution: code.Text})
+	if details, ok := V.Details.Obj.(*eval.ErrorDetails); !ok || !strings.Contains(details.Diagnostics, "Test 1") {
+		t.Errorf("The diagnostics of a test should be for the administrators (are %v)", V.Details.Obj)
+	}
+}
+
+func TestReadLimits(t *testing.T) {
+	dir, err := ioutil.TempDir("", "limits")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := dir + "/limits"
+	ioutil.WriteFile(path, []byte("Time 1\nMemory 1000\nGo Time x3\ngo Time +0.5\n"), 0600)
+	L, err := readLimits(path)
+	if err != nil {
+		t.Fatalf("Cannot read limits: %s", err)
+	}
+	if L.limits.Time != 1 || L.limits.Memory != 1000 || len(L.lang) != 2 {
+		t.Fatalf("Wrong limits: %+v", L)
+	}
+	E := Evaluator{Limits: L.limits, LangLimits: L.lang}
+	tester := &FilesTester{Limits: &Constraints{Time: 5}}
+	tests := []struct {
+		tester Tester
+		lang   string
+		time   float64
+	}{
+		{&InputTester{}, "C++", 1},
+		{&InputTester{}, "Go", 3.5},
+		{tester, "C++", 5},
+		{tester, "Go", 15.5},
+	}
+	for _, test := range tests {
+		L := E.limits(test.tester, test.lang, nil)
+		if L.Time != test.time || L.Memory != 1000 {
+			t.Errorf("%T in %s: wrong limits %+v", test.tester, test.lang, L)
+		}
+	}
+	wrongs := []string{
+		"Cobol Time x2", "Go Time -1", "Go Speed x2", "Output model x2", "Timee 1", "Time 1s",
+		"Time", "Go Time x3 +0.5", "Memory 1000\nGo Time x3",
+	}
+	for _, wrong := range wrongs {
+		ioutil.WriteFile(path, []byte(wrong), 0600)
+		if _, err := readLimits(path); err == nil {
+			t.Errorf("'%s' should be an error", wrong)
+		}
+	}
+}
+
+func TestResultLimits(t *testing.T) {
+	ev := &Evaluator{
+		Limits: Constraints{Time: 2},
+		Tests:  []db.Obj{{&InputTester{}}},
+	}
+	prob := &eval.Problem{Solution: Minimal, Evaluator: db.Obj{ev}}
+	V := evaluate(ev, prob, Minimal)
+	if L := results(V)[0].Limits; L == nil || L.Time != 2 {
+		t.Errorf("The result should have the limits (are %+v)", L)
+	}
+}
+
+func TestModelLimits(t *testing.T) {
+	ev := &Evaluator{
+		Limits:      Constraints{Time: 1},
+		ModelLimits: []ModelLimit{{"Time", 3, 0.5}, {"Memory", 2, 0}},
+		Tests:       []db.Obj{{&InputTester{Input: "model limits"}}},
+	}
+	prob := &eval.Problem{Solution: Minimal, Evaluator: db.Obj{ev}}
+	var first TestResult
+	for i := 0; i < 2; i++ {
+		R := results(evaluate(ev, prob, Minimal))[0]
+		if R.Veredict != "Accepted" || R.Model == nil || R.Limits == nil {
+			t.Fatalf("Should be accepted with derived limits (is %s)", R)
+		}
+		if R.Limits.Time != 3*float64(R.Model.Seconds)+0.5 {
+			t.Errorf("Wrong time limit %.3f (the model %.3f)", R.Limits.Time, R.Model.Seconds)
+		}
+		if R.Limits.Memory != int(2*float64(R.Model.Megabytes)*1024*1024) {
+			t.Errorf("Wrong memory limit %d (the model %.1f MB)", R.Limits.Memory, R.Model.Megabytes)
+		}
+		if i == 0 {
+			first = R
+		} else if *R.Model != *first.Model {
+			t.Errorf("The performance of the model should be cached")
+		}
+	}
+}
+
+func TestPerfCache(t *testing.T) {
+	c := newPerfCache(2)
+	perf := func(s float32) Performance { return Performance{Seconds: s} }
+	c.get("a", perf(1))
+	c.get("b", perf(2))
+	if p := c.get("a", perf(10)); p.Seconds != 1 { // (now "b" is the oldest)
+		t.Errorf("The performance of 'a' should be cached (is %v)", p)
+	}
+	c.get("c", perf(3))
+	if p := c.get("b", perf(20)); p.Seconds != 20 {
+		t.Errorf("The least recently used ('b') should be evicted (is %v)", p)
+	}
+	if len(c.perf) != 2 || c.order.Len() != 2 {
+		t.Errorf("The cache should have at most 2 entries (has %d)", len(c.perf))
+	}
+}
+
+func TestPerformance(t *testing.T) {
+	V := evalWithInputs(Minimal, Minimal, []string{"", ""})
+	details := V.Details.Obj.(VeredictDetails)
+	var max Performance
+	for i, R := range details.Results {
+		if R.Performance == nil {
+			t.Fatalf("Test %d should have the performance of the accused", i+1)
+		}
+		if perf, ok := R.Reason.Obj.(Performance); !ok || perf != *R.Performance {
+			t.Errorf("Test %d: the reason should be the performance of the accused", i+1)
+		}
+		if R.Performance.Megabytes > max.Megabytes {
+			max.Megabytes = R.Performance.Megabytes
+		}
+	}
+	if details.Max().Megabytes != max.Megabytes || details.Total().Megabytes < max.Megabytes {
+		t.Errorf("Wrong maximum (%v) or total (%v)", details.Max(), details.Total())
+	}
+	if table := details.Table(); !strings.Contains(table, "Max") || !strings.Contains(table, "Total") {
+		t.Errorf("Wrong table:\n%s", table)
+	}
+}
+
+const slowMinimal = `.cc
+int main() {
+  volatile long x = 0;
+  for (long i = 0; i < 400000000; i++) x += i;
+}`
+
+func TestTooSlow(t *testing.T) {
+	ev := &Evaluator{
+		Limits: Constraints{Time: 10, WallTime: 30},
+		Tests:  []db.Obj{{&FilesTester{Options: map[string]bool{"tooslow": true}, SlowFactor: 2}}},
+	}
+	prob := &eval.Problem{Solution: Minimal, Evaluator: db.Obj{ev}}
+	if V := evaluate(ev, prob, Minimal); V.Message != "Accepted" {
+		t.Errorf("The model should be accepted (is '%s')", V.Message)
+	}
+	V := evaluate(ev, prob, slowMinimal)
+	if V.Message != "Too Slow" {
+		t.Errorf("Should be 'Too Slow' (is '%s'):\n%v", V.Message, V.Details.Obj)
+	}
+
+	dir, err := ioutil.TempDir("", "tooslow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for options, factor := range map[string]float64{"tooslow\n": 0, "sort\ntooslow 5\n": 5, "tooslow x": -1} {
+		ioutil.WriteFile(dir+"/options", []byte(options), 0600)
+		var I FilesTester
+		err := I.ReadFrom(dir)
+		if factor < 0 {
+			if err == nil {
+				t.Errorf("'%s' should be an error", options)
+			}
+		} else if err != nil || !I.Options["tooslow"] || I.SlowFactor != factor {
+			t.Errorf("Wrong options from '%s': %v %g (%v)", options, I.Options, I.SlowFactor, err)
+		}
+	}
+}
+
+const sortGo = `package main
+
+import "sort"
+
+func fact(n int) int {
+	if n == 0 {
+		return 1
+	}
+	return n * fact(n-1)
+}
+
+func main() {
+	sort.Ints([]int{fact(3), 2, 1})
+}
+`
+
+func TestRules(t *testing.T) {
+	rules := func(lines ...string) []Rule {
+		var R []Rule
+		for _, line := range lines {
+			r, err := parseRule(line)
+			if err != nil {
+				t.Fatalf("Cannot parse '%s': %s", line, err)
+			}
+			R = append(R, r)
+		}
+		return R
+	}
+	goFunc := func(funcs ...string) Code {
+		text := "package main\nimport \"sort\"\ntype T struct{ sort.IntSlice }\n"
+		return Code{Lang: "Go", Text: text + strings.Join(funcs, "\n") + "\n"}
+	}
+	cpp := Code{Lang: "C++", Text: "#include <algorithm>\nint main() {\n  std::sort(0, 0);\n}\n"}
+	goCode := Code{Lang: "Go", Text: sortGo}
+	cppQuoted := Code{Lang: "C++", Text: "// std::sort\n/* std::sort\n */ const char *s = \"\\\"std::sort\";\nint main() { std::sort(0, 0); }\n"}
+	tests := []struct {
+		rules []string
+		code  Code
+		line  int // of the violation (-1 if none)
+	}{
+		{[]string{`forbid regexp std::sort`}, cpp, 3},
+		{[]string{`forbid regexp std::sort`}, cppQuoted, 4}, // (not in comments or strings)
+		{[]string{`require regexp sort`}, Code{Lang: "C++", Text: "// sort\nint main() {}\n"}, 0},
+		{[]string{`Go forbid regexp std::sort`}, cpp, -1},
+		{[]string{`require regexp for\s*\(`}, cpp, 0},
+		{[]string{`forbid goto`, `require recursion`}, cpp, -1}, // (only Go)
+		{[]string{`forbid call sort.Ints`}, goCode, 13},
+		{[]string{`forbid goto`, `require recursion`, `maxlines 10`}, goCode, -1},
+		{[]string{`maxlines 3`}, goCode, 5},
+		{[]string{`require recursion`}, Code{Lang: "Go", Text: "package main\nfunc main() {}\n"}, 0},
+		{[]string{`require recursion`}, goFunc(`func Sort(x sort.IntSlice) { sort.Sort(x) }`), 0},
+		{[]string{`require recursion`}, goFunc(`func (t T) f(n int) { if n > 0 { t.f(n - 1) } }`), -1},
+		{[]string{`require recursion`}, goFunc(`func (t T) f() { f() }`, `func f() {}`), 0},
+	}
+	for _, test := range tests {
+		v := checkRules(rules(test.rules...), test.code)
+		switch {
+		case test.line < 0 && v != nil:
+			t.Errorf("%v: unexpected violation %s", test.rules, v)
+		case test.line >= 0 && (v == nil || v.Line != test.line):
+			t.Errorf("%v: expected a violation at line %d (is %v)", test.rules, test.line, v)
+		}
+	}
+	for _, wrong := range []string{"forbid", "forbid regexp (", "require goto", "maxlines x", "C++ forbid goto"} {
+		if _, err := parseRule(wrong); err == nil {
+			t.Errorf("'%s' should be an error", wrong)
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "rules")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if R, err := readRules(dir + "/rules"); R != nil || err != nil {
+		t.Errorf("Without a file there should be no rules (%v, %v)", R, err)
+	}
+	os.Mkdir(dir+"/rules", 0700) // (cannot be read)
+	if _, err := readRules(dir + "/rules"); err == nil {
+		t.Errorf("A 'rules' file that cannot be read should be an error")
+	}
+
+	// Evaluate gives "Rule Violation" (before compiling)
+	ev := &Evaluator{Rules: rules("forbid regexp int main"), Tests: []db.Obj{{&InputTester{}}}}
+	prob := &eval.Problem{Solution: Minimal, Evaluator: db.Obj{ev}}
+	V := evaluate(ev, prob, Minimal)
+	if v, ok := V.Details.Obj.(*RuleViolation); V.Message != "Rule Violation" || !ok || v.Line != 1 {
+		t.Errorf("Should be a 'Rule Violation' at line 1 (is '%s': %v)", V.Message, V.Details.Obj)
+	}
+}
+
+func TestWorstVeredict(t *testing.T) {
+	tests := []struct {
+		veredicts []string
+		worst     string
+	}{
+		{[]string{"Accepted", "Wrong Answer", "Execution Error"}, "Execution Error"},
+		{[]string{"Accepted", "Non-Zero Status", "Wrong Answer"}, "Non-Zero Status"},
+		{[]string{"Accepted", "Strange", "Accepted"}, "Strange"}, // (not in the list)
+		{[]string{"Accepted", "Accepted"}, "Accepted"},
+		{nil, "<No message>"},
+	}
+	for _, test := range tests {
+		var results []TestResult
+		for _, v := range test.veredicts {
+			results = append(results, TestResult{Veredict: v})
+		}
+		if worst := worstVeredict(results); worst != test.worst {
+			t.Errorf("The veredict of %v should be '%s' (is '%s')", test.veredicts, test.worst, worst)
+		}
+	}
+}
+
+func TestReadLanguages(t *testing.T) {
+	dir, err := ioutil.TempDir("", "languages")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := dir + "/languages"
+	if langs, err := readLanguages(path); langs != nil || err != nil {
+		t.Errorf("Without a file all languages should be allowed (%v, %v)", langs, err)
+	}
+	ioutil.WriteFile(path, []byte("c++\n\n.go\n"), 0600)
+	if langs, err := readLanguages(path); err != nil || strings.Join(langs, " ") != "C++ Go" {
+		t.Errorf("Wrong languages %v (%v)", langs, err)
+	}
+	ioutil.WriteFile(path, []byte("Cobol\n"), 0600)
+	if _, err := readLanguages(path); err == nil {
+		t.Errorf("An unknown language should be an error")
+	}
+	os.Remove(path)
+	os.Mkdir(path, 0700) // (cannot be read)
+	if _, err := readLanguages(path); err == nil {
+		t.Errorf("A file that cannot be read should be an error")
+	}
+}
@@ -12,4 +12,6 @@ func init() {
 	db.Register("prob.SimpleReason", SimpleReason{})
 	db.Register("prob.GoodVsBadReason", GoodVsBadReason{})
 	db.Register("prog.test.[]Result", []TestResult{})
+	db.Register("prog.CompilationDiagnostics", CompilationDiagnostics{})
+	db.Register("prog.RuleViolation", RuleViolation{})
 }
@@ -0,0 +1,294 @@
+package programming
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pauek/garzon/eval/programming/lang"
+)
+
+// Rules are checks of the source code of the accused, made before
+// compiling it, which give a "Rule Violation" veredict. The 'rules'
+// file of a problem has one rule per line (and comments with '#'):
+//
+//   forbid regexp <re>     the code cannot match <re>
+//   require regexp <re>    the code must match <re>
+//   forbid goto            no goto statements
+//   forbid call <name>     no calls to <name> (e.g. 'sort.Ints')
+//   require recursion      some function must call itself
+//   maxlines <n>           functions of at most <n> lines
+//
+// A rule can start with a language (e.g. 'C++ forbid regexp std::sort')
+// to apply only to programs in that language. The regexps don't see
+// comments or the contents of literal strings and characters (which
+// are blanked). The rules other than 'regexp' look at the syntax tree
+// (go/ast), so they only apply to Go.
+
+type Rule struct {
+	Lang  string `json:",omitempty"` // only for this language (all if "")
+	Kind  string // "forbid", "require" or "maxlines"
+	Check string `json:",omitempty"` // "regexp", "goto", "call" or "recursion"
+	Arg   string `json:",omitempty"`
+}
+
+func (r Rule) String() string {
+	var fields []string
+	for _, f := range []string{r.Lang, r.Kind, r.Check, r.Arg} {
+		if f != "" {
+			fields = append(fields, f)
+		}
+	}
+	return strings.Join(fields, " ")
+}
+
+// A RuleViolation is the reason of a "Rule Violation" veredict.
+type RuleViolation struct {
+	Rule string
+	Line int    `json:",omitempty"` // of the violation (0 if none)
+	Text string `json:",omitempty"` // of the line
+}
+
+func (v RuleViolation) String() string {
+	if v.Line == 0 {
+		return fmt.Sprintf("Rule '%s' violated\n", v.Rule)
+	}
+	return fmt.Sprintf("Rule '%s' violated at line %d:\n   %s\n", v.Rule, v.Line, v.Text)
+}
+
+// parseRule parses a line of a 'rules' file.
+func parseRule(line string) (r Rule, err error) {
+	fields := strings.Fields(line)
+	if len(fields) > 1 {
+		if L := lang.Find(fields[0]); L != nil {
+			r.Lang = L.Name
+			fields = fields[1:]
+		}
+	}
+	r.Kind = fields[0]
+	switch {
+	case r.Kind == "maxlines" && len(fields) == 2:
+		if n, err := strconv.Atoi(fields[1]); err != nil || n <= 0 {
+			return r, fmt.Errorf("Wrong number of lines '%s'", fields[1])
+		}
+		r.Arg = fields[1]
+	case (r.Kind == "forbid" || r.Kind == "require") && len(fields) >= 2:
+		r.Check = fields[1]
+		switch {
+		case r.Check == "regexp" && len(fields) >= 3:
+			i := strings.Index(line, "regexp") + len("regexp")
+			r.Arg = strings.TrimSpace(line[i:]) // (with its spaces)
+			if _, err := regexp.Compile(r.Arg); err != nil {
+				return r, fmt.Errorf("Wrong regexp '%s': %s", r.Arg, err)
+			}
+		case r.Check == "goto" && r.Kind == "forbid" && len(fields) == 2:
+		case r.Check == "call" && r.Kind == "forbid" && len(fields) == 3:
+			r.Arg = fields[2]
+		case r.Check == "recursion" && r.Kind == "require" && len(fields) == 2:
+		default:
+			return r, fmt.Errorf("Wrong rule '%s'", line)
+		}
+	default:
+		return r, fmt.Errorf("Wrong rule '%s'", line)
+	}
+	if r.Check != "regexp" && r.Lang != "" && r.Lang != "Go" {
+		return r, fmt.Errorf("Rule '%s' only applies to Go", line)
+	}
+	return r, nil
+}
+
+// readRules reads the 'rules' file of a problem (if it exists).
+func readRules(path string) (rules []Rule, err error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("Cannot read '%s': %s", path, err)
+	}
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		r, err := parseRule(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", path, i+1, err)
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// checkRules checks the rules for the code of the accused, and gives
+// the first violation (nil if there is none).
+func checkRules(rules []Rule, code Code) *RuleViolation {
+	lines := strings.Split(code.Text, "\n")
+	violation := func(r Rule, line int) *RuleViolation {
+		v := &RuleViolation{Rule: r.String(), Line: line}
+		if line > 0 && line <= len(lines) {
+			v.Text = strings.TrimSpace(lines[line-1])
+		}
+		return v
+	}
+	var stripped string // (without comments and literals, when needed)
+	var file *ast.File  // (parsed when needed)
+	fset := token.NewFileSet()
+	for _, r := range rules {
+		if r.Lang != "" && r.Lang != code.Lang {
+			continue
+		}
+		if r.Check == "regexp" {
+			if stripped == "" {
+				stripped = stripCode(code.Text)
+			}
+			re := regexp.MustCompile(r.Arg) // (checked in parseRule)
+			loc := re.FindStringIndex(stripped)
+			switch {
+			case r.Kind == "forbid" && loc != nil:
+				return violation(r, strings.Count(stripped[:loc[0]], "\n")+1)
+			case r.Kind == "require" && loc == nil:
+				return violation(r, 0)
+			}
+			continue
+		}
+		if code.Lang != "Go" {
+			continue
+		}
+		if file == nil {
+			var err error
+			if file, err = parser.ParseFile(fset, "accused.go", code.Text, 0); err != nil {
+				return nil // (the compiler will tell)
+			}
+		}
+		if pos := checkAst(r, file, fset); pos >= 0 {
+			return violation(r, pos)
+		}
+	}
+	return nil
+}
+
+// stripCode blanks the comments and the contents of the literal
+// strings and characters of a program (in C++ or Go), keeping the
+// quotes, the newlines and so the line numbers.
+func stripCode(text string) string {
+	b := []byte(text)
+	blank := func(i int) {
+		if b[i] != '\n' {
+			b[i] = ' '
+		}
+	}
+	for i := 0; i < len(b); i++ {
+		switch {
+		case b[i] == '/' && i+1 < len(b) && b[i+1] == '/':
+			for ; i < len(b) && b[i] != '\n'; i++ {
+				blank(i)
+			}
+		case b[i] == '/' && i+1 < len(b) && b[i+1] == '*':
+			for ; i < len(b) && !(b[i] == '*' && i+1 < len(b) && b[i+1] == '/'); i++ {
+				blank(i)
+			}
+			if i < len(b) {
+				blank(i)
+				i++
+				blank(i)
+			}
+		case b[i] == '"' || b[i] == '\'' || b[i] == '`':
+			quote := b[i]
+			for i++; i < len(b) && b[i] != quote; i++ {
+				if quote != '`' && b[i] == '\n' {
+					break // (unterminated)
+				}
+				if quote != '`' && b[i] == '\\' && i+1 < len(b) && b[i+1] != '\n' {
+					blank(i)
+					i++
+				}
+				blank(i)
+			}
+		}
+	}
+	return string(b)
+}
+
+// checkAst checks a rule in the syntax tree of a Go program, and gives
+// the line of the violation (0 if it has no line, -1 if none).
+func checkAst(r Rule, file *ast.File, fset *token.FileSet) int {
+	line := func(n ast.Node) int { return fset.Position(n.Pos()).Line }
+	found := -1
+	switch {
+	case r.Check == "goto":
+		ast.Inspect(file, func(n ast.Node) bool {
+			if b, ok := n.(*ast.BranchStmt); ok && b.Tok == token.GOTO && found < 0 {
+				found = line(b)
+			}
+			return found < 0
+		})
+	case r.Check == "call":
+		ast.Inspect(file, func(n ast.Node) bool {
+			if c, ok := n.(*ast.CallExpr); ok && callName(c) == r.Arg && found < 0 {
+				found = line(c)
+			}
+			return found < 0
+		})
+	case r.Check == "recursion":
+		for _, d := range file.Decls {
+			if f, ok := d.(*ast.FuncDecl); ok && f.Body != nil && callsItself(f) {
+				return -1
+			}
+		}
+		found = 0
+	case r.Kind == "maxlines":
+		max, _ := strconv.Atoi(r.Arg)
+		for _, d := range file.Decls {
+			f, ok := d.(*ast.FuncDecl)
+			if ok && fset.Position(f.End()).Line-line(f)+1 > max {
+				return line(f)
+			}
+		}
+	}
+	return found
+}
+
+// callName gives the name of the function of a call (e.g. "sort.Ints"
+// or "f"), or "" for other calls.
+func callName(c *ast.CallExpr) string {
+	switch fun := c.Fun.(type) {
+	case *ast.Ident:
+		return fun.Name
+	case *ast.SelectorExpr:
+		if x, ok := fun.X.(*ast.Ident); ok {
+			return x.Name + "." + fun.Sel.Name
+		}
+		return fun.Sel.Name
+	}
+	return ""
+}
+
+// callsItself tells if a function calls itself, by its name (e.g. 'f()')
+// or, for a method, with its receiver (e.g. 't.f()').
+func callsItself(f *ast.FuncDecl) (found bool) {
+	recv := ""
+	if f.Recv != nil && len(f.Recv.List) > 0 && len(f.Recv.List[0].Names) > 0 {
+		recv = f.Recv.List[0].Names[0].Name
+	}
+	ast.Inspect(f.Body, func(n ast.Node) bool {
+		c, ok := n.(*ast.CallExpr)
+		if !ok {
+			return !found
+		}
+		switch fun := c.Fun.(type) {
+		case *ast.Ident:
+			found = found || (f.Recv == nil && fun.Name == f.Name.Name)
+		case *ast.SelectorExpr:
+			x, ok := fun.X.(*ast.Ident)
+			found = found || (ok && recv != "" && recv != "_" && x.Name == recv && fun.Sel.Name == f.Name.Name)
+		}
+		return !found
+	})
+	return
+}
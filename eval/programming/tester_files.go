@@ -9,6 +9,7 @@ import (
 	"os/exec"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -18,12 +19,22 @@ func init() {
 
 // A FilesTester creates some input files, and checks that some 
 // output files are created by the program and have the same
-// contents as the files created by the model program
+// contents as the files created by the model program. The options
+// (one per line in the file 'options') are:
+//
+//   sort           compare the lines of the output sorted
+//   blocks         compare blocks of lines (separated by blank lines)
+//   performance    don't show the differences of the output
+//   tooslow [f]    "Too Slow" if the accused takes more than f times
+//                  the CPU time of the model (3 by default)
+//
 type FilesTester struct {
 	Input       string
 	InputFiles  []FileInfo
 	OutputFiles []FileInfo
 	Options     map[string]bool `json:",omitempty"`
+	SlowFactor  float64         `json:",omitempty"` // of the model's time (option 'tooslow')
+	Limits      *Constraints    `json:",omitempty"` // of this test (see limits.go)
 	state       *InputTesterState
 }
 
@@ -37,6 +48,8 @@ type FileTesterState struct {
 	modelOutFiles, accusedOutFiles [][]byte
 }
 
+func (I FilesTester) TestLimits() *Constraints { return I.Limits }
+
 func (I FilesTester) Prepare(C *context) {
 	state := new(FileTesterState)
 	n := len(I.OutputFiles)
@@ -103,9 +116,9 @@ func (I FilesTester) CleanUp(C *context) (err error) {
 	// remember performance
 	switch C.Mode() {
 	case "model":
-		state.modelPerf = parsePerformance(C.stderr)
+		state.modelPerf = C.report.performance()
 	case "accused":
-		state.accusedPerf = parsePerformance(C.stderr)
+		state.accusedPerf = C.report.performance()
 	}
 	return nil
 }
@@ -176,7 +189,46 @@ func (I FilesTester) Veredict(C *context) TestResult {
 			Reason:   db.Obj{reason},
 		}
 	}
-	return TestResult{Veredict: "Accepted", Reason: db.Obj{state.modelPerf}}
+	if I.Options["tooslow"] && tooSlow(state.accusedPerf, state.modelPerf, I.SlowFactor) {
+		return TestResult{
+			Veredict: "Too Slow",
+			Reason: db.Obj{&SimpleReason{fmt.Sprintf("%.3f sec (the model: %.3f sec, at most %gx)",
+				state.accusedPerf.Seconds, state.modelPerf.Seconds, slowFactor(I.SlowFactor))}},
+		}
+	}
+	return TestResult{Veredict: "Accepted", Reason: db.Obj{state.accusedPerf}}
+}
+
+// Efficiency
+
+const (
+	defaultSlowFactor = 3
+	minSlowSeconds    = 0.1 // (shorter times are mostly noise)
+)
+
+func slowFactor(factor float64) float64 {
+	if factor <= 0 {
+		return defaultSlowFactor
+	}
+	return factor
+}
+
+// tooSlow tells if the accused took more than factor times the CPU
+// time of the model.
+func tooSlow(accused, model Performance, factor float64) bool {
+	limit := slowFactor(factor) * float64(model.Seconds)
+	return float64(accused.Seconds) > limit && accused.Seconds > minSlowSeconds
+}
+
+func readSlowFactor(args []string) (float64, error) {
+	if len(args) == 0 {
+		return 0, nil // (the default)
+	}
+	factor, err := strconv.ParseFloat(args[0], 64)
+	if err != nil || factor < 1 {
+		return 0, fmt.Errorf("Wrong factor '%s' for 'tooslow'", args[0])
+	}
+	return factor, nil
 }
 
 func (I *FilesTester) ReadFrom(path string) (err error) {
@@ -195,6 +247,16 @@ func (I *FilesTester) ReadFrom(path string) (err error) {
 	if err != nil {
 		return err
 	}
+	if fileExists(path + "/limits") {
+		limits, err := readLimits(path + "/limits")
+		if err != nil {
+			return err
+		}
+		if len(limits.lang) > 0 || len(limits.model) > 0 {
+			return fmt.Errorf("FilesTester.ReadFrom: '%s/limits' can only have plain limits\n", path)
+		}
+		I.Limits = &limits.limits
+	}
 	if fileExists(path + "/options") {
 		text, err := ioutil.ReadFile(path + "/options")
 		if err != nil {
@@ -203,9 +265,15 @@ func (I *FilesTester) ReadFrom(path string) (err error) {
 		I.Options = make(map[string]bool)
 		lines := strings.Split(string(text), "\n")
 		for _, line := range lines {
-			opt := strings.Trim(line, " \t")
-			if opt != "" {
-				I.Options[opt] = true
+			fields := strings.Fields(line)
+			if len(fields) == 0 {
+				continue
+			}
+			I.Options[fields[0]] = true
+			if fields[0] == "tooslow" {
+				if I.SlowFactor, err = readSlowFactor(fields[1:]); err != nil {
+					return fmt.Errorf("FilesTester.ReadFrom: '%s/options': %s\n", path, err)
+				}
 			}
 		}
 	}
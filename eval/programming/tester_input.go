@@ -51,9 +51,9 @@ func (I InputTester) CleanUp(C *context) error {
 	S := C.State.(*InputTesterState)
 	switch C.Mode() {
 	case "model":
-		S.modelPerf = parsePerformance(C.stderr)
+		S.modelPerf = C.report.performance()
 	case "accused":
-		S.accusedPerf = parsePerformance(C.stderr)
+		S.accusedPerf = C.report.performance()
 	}
 	return nil
 }
@@ -63,7 +63,7 @@ func (I InputTester) Veredict(C *context) TestResult {
 	a, b := S.modelOut.String(), S.accusedOut.String()
 
 	if a == b {
-		return TestResult{Veredict: "Accepted", Reason: db.Obj{S.modelPerf}}
+		return TestResult{Veredict: "Accepted", Reason: db.Obj{S.accusedPerf}}
 	}
 	return TestResult{
 		Veredict: "Wrong Answer",
@@ -0,0 +1,36 @@
+package programming
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// A UidPool has the uids (and gids) in which programs run (see
+// grz-jail -u), so that they cannot read the files of grz-eval (e.g.
+// the model, or other submissions). Each run takes a uid from the pool
+// (waiting if there is none) and gives it back at the end, so that
+// simultaneous evaluations have different users. grz-eval must run as
+// root, and BaseDir must be searchable by the users (e.g. 0711).
+type UidPool chan int
+
+// NewUidPool makes a pool with the uids from first to last.
+func NewUidPool(first, last int) UidPool {
+	pool := make(UidPool, last-first+1)
+	for uid := first; uid <= last; uid++ {
+		pool <- uid
+	}
+	return pool
+}
+
+func (p UidPool) Get() int    { return <-p }
+func (p UidPool) Put(uid int) { p <- uid }
+
+// chownTree makes the files in dir (and dir itself) owned by uid.
+func chownTree(dir string, uid int) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		return os.Lchown(path, uid, uid)
+	})
+}
@@ -34,14 +34,3 @@ func seeSpace(a string) (s string) {
 	}
 	return
 }
-
-func parsePerformance(s string) (perf Performance) {
-	lines := strings.Split(s, "\n")
-	if len(lines) > 1 {
-		fmt.Sscanf(lines[1], "%f", &perf.Seconds)
-	}
-	if len(lines) > 2 {
-		fmt.Sscanf(lines[2], "%f", &perf.Megabytes)
-	}
-	return
-}
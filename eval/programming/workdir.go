@@ -0,0 +1,80 @@
+package programming
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Each evaluation has its own working directory in BaseDir (see
+// CreateDirectory), named 'grz-<id>-<random>', with the pid of the
+// process that created it in '.pid'. Directories are removed after the
+// evaluation (unless KeepFiles), but if grz-eval crashes they are left
+// behind, and then RemoveStaleDirs removes them. Only directories with
+// a '.pid' are removed (others in BaseDir, like '~/grz-jail', are not
+// ours), so RemoveStaleDirs should only be used with a BaseDir of its
+// own (grz-eval -t).
+
+const (
+	dirPrefix = "grz-"
+	pidFile   = ".pid"
+)
+
+// alive tells if a process exists.
+func alive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
+
+// isStale tells if the working directory 'dir' was left behind by a
+// process that doesn't exist anymore (it must have a '.pid' with it).
+func isStale(dir string) bool {
+	data, err := ioutil.ReadFile(filepath.Join(dir, pidFile))
+	if err != nil {
+		return false // (not a working directory, or not yet)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pid <= 0 {
+		return false
+	}
+	return pid != os.Getpid() && !alive(pid)
+}
+
+// RemoveStaleDirs removes the working directories in BaseDir of
+// evaluations whose process is gone, and returns how many.
+func RemoveStaleDirs() (int, error) {
+	dirs, err := filepath.Glob(filepath.Join(BaseDir, dirPrefix+"*"))
+	if err != nil {
+		return 0, fmt.Errorf("Cannot look for directories in '%s': %s", BaseDir, err)
+	}
+	n := 0
+	for _, dir := range dirs {
+		if info, err := os.Lstat(dir); err != nil || !info.IsDir() {
+			continue
+		}
+		if isStale(dir) {
+			if err := os.RemoveAll(dir); err != nil {
+				return n, fmt.Errorf("Couldn't remove directory '%s': %s", dir, err)
+			}
+			log.Printf("Removed stale directory '%s'", dir)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// Janitor calls RemoveStaleDirs periodically (it doesn't return).
+func Janitor(period time.Duration) {
+	for {
+		if _, err := RemoveStaleDirs(); err != nil {
+			log.Printf("Janitor: %s", err)
+		}
+		time.Sleep(period)
+	}
+}
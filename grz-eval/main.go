@@ -3,15 +3,17 @@ package main
 import (
 	"flag"
 	"fmt"
-"strings"
+	"io"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
+	"time"
 	"code.google.com/p/go.net/websocket"
 
 	"github.com/pauek/garzon/eval"
 	prog "github.com/pauek/garzon/eval/programming"
+	"github.com/pauek/garzon/eval/programming/lang"
 )
 
 const usage = `usage: grz-eval [options...]
@@ -19,38 +21,53 @@ const usage = `usage: grz-eval [options...]
 Options:
 	-p <port>,   Port to listen on (60000)
 	-j <path>,   Location of 'grz-jail'
-	-t,          Use temp directory
+	-s,          Use seccomp filters in grz-jail
+	-n,          Use namespaces in grz-jail
+	-g <dir>,    Parent cgroup (v2) for grz-jail
+	-G,          Use the Go jail (instead of grz-jail)
+	-U <a>-<b>,  Run programs as users a to b (as root)
+	-t,          Use temp directory (and clean it periodically)
    -k,          Keep Files
 
 `
 
+// submissions evaluates the submissions received through ws, one at a
+// time, until the connection is closed. A submission always gets a
+// "Resolved" response, with an error veredict if the judge fails.
 func submissions(ws *websocket.Conn) {
 	for {
 		var sub eval.Submission
+		var V eval.Veredict
 		err := websocket.JSON.Receive(ws, &sub)
+		if err == io.EOF {
+			return
+		}
 		if err != nil {
 			log.Printf("websocket.JSON.Receive error: %s", err)
-			err := websocket.JSON.Send(ws, eval.Response{Status: "Error"})
-			if err != nil {
-				log.Printf("websocket.JSON.Send 'Error' error: %s", err)
+			V = eval.ErrorVeredict(eval.JudgeError, fmt.Sprintf("Cannot receive the submission: %s", err))
+		} else {
+			progress := make(chan string)
+			go eval.Submit(sub, &V, progress)
+			for {
+				msg := <- progress
+				if msg == "Resolved" {
+					break
+				}
+				err = websocket.JSON.Send(ws, eval.Response{Status: msg, Veredict: nil})
+				if err != nil {
+					log.Printf("websocket.JSON.Send '%s' error: %s", msg, err)
+				}
 			}
 		}
-		var V eval.Veredict
-		progress := make(chan string)
-		go eval.Submit(sub, &V, progress)
-		for {
-			msg := <- progress
-			if msg == "Resolved" || strings.HasPrefix(msg, "Error") {
-				break
-			}
-			err = websocket.JSON.Send(ws, eval.Response{Status: msg, Veredict: nil})
-			if err != nil {
-				log.Printf("websocket.JSON.Send '%s' error: %s", msg, err)
+		if V.IsError() {
+			if details, ok := V.Details.Obj.(*eval.ErrorDetails); ok {
+				log.Printf("%s (%s): %s", V.Message, sub.ProblemID, details.Diagnostics)
 			}
 		}
 		err = websocket.JSON.Send(ws, eval.Response{Status: "Resolved", Veredict: &V})
 		if err != nil {
 			log.Printf("websocket.JSON.Send 'Resolved' error: %s", err)
+			return
 		}
 	}
 }
@@ -63,17 +80,35 @@ func main() {
 	keep := flag.Bool("k", false, "Keep Files")
 	temp := flag.Bool("t", false, "Temp directory")
 	grzjail := flag.String("j", "grz-jail", "Location of grz-jail")
+	seccomp := flag.Bool("s", false, "Seccomp filters")
+	namespaces := flag.Bool("n", false, "Namespaces")
+	cgroup := flag.String("g", "", "Parent cgroup")
+	gojail := flag.Bool("G", false, "Go jail")
+	uids := flag.String("U", "", "Range of uids")
 	flag.Parse()
 
 	prog.KeepFiles = *keep
 	prog.GrzJail = *grzjail
+	prog.Seccomp = *seccomp
+	prog.Namespaces = *namespaces
+	prog.Cgroup = *cgroup
+	prog.GoJail = *gojail
+	if *uids != "" {
+		var first, last int
+		if n, _ := fmt.Sscanf(*uids, "%d-%d", &first, &last); n != 2 || first <= 0 || first > last {
+			log.Fatalf("Wrong range of uids '%s'\n", *uids)
+		}
+		prog.Uids = prog.NewUidPool(first, last)
+	}
+	lang.GrzJail = *grzjail
 	if *temp {
 		tmpdir := filepath.Join(os.TempDir(), "grz-eval")
 		_ = os.RemoveAll(tmpdir)
-		if err := os.Mkdir(tmpdir, 0700); err != nil {
+		if err := os.Mkdir(tmpdir, 0711); err != nil { // (see -U)
 			log.Fatal("Couldn't make directory '%s'\n", tmpdir)
 		}
 		prog.BaseDir = tmpdir
+		go prog.Janitor(10 * time.Minute) // (directories of crashed evaluations)
 	}
 	log.Printf("grz-eval: starting server\n")
 	http.Handle("/ws", websocket.Handler(submissions))
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+var (
+	MaxCpuSeconds  float64
+	MaxWallSeconds float64
+	MaxIdleSeconds float64
+	MaxMemory      int
+	MaxFileSize    int
+	MaxOutput      int
+	StderrFile     string
+	AccusedMode    bool
+	SeccompMode    bool
+	Namespaces     bool
+	CgroupParent   string
+	MaxPids        int
+	SandboxUid     int
+	CompileOutput  string
+	JSONReport     bool
+	PolicyFile     string
+	AuditFile      string
+)
+
+const s_usage = `usage: grz-jail [options...] <directory>
+
+Options:
+   -m <mem>   Max megabytes of memory
+   -t <sec>   Max CPU seconds (e.g. 0.5)
+   -w <sec>   Max wall clock seconds
+   -i <sec>   Max seconds without using the CPU
+   -f <mem>   Max megabytes for files
+   -o <bytes> Max bytes of standard output
+   -e <file>  Save standard error to <file>
+   -a         Accused mode
+   -P <file>  Policy file (with -a)
+   -A <file>  Audit log of the last syscalls
+   -s         Seccomp mode (with -a)
+   -n         Run inside new namespaces
+   -g <dir>   Run in a cgroup created inside <dir>
+   -p <num>   Max processes and threads (with -g)
+   -u <uid>   Run as user (and group) <uid>
+   -c <file>  Compiler mode (output to <file>)
+   -json      Report in JSON
+
+usage: grz-jail -c <file> [options...] <directory> <command> [<args>...]
+
+`
+
+func usage() {
+	fmt.Fprintf(os.Stderr, s_usage)
+}
+
+// parseFlags parses the options (and checks the number of arguments).
+func parseFlags() []string {
+	flag.Usage = usage
+	flag.Float64Var(&MaxCpuSeconds, "t", 2, "<dummy>")
+	flag.Float64Var(&MaxWallSeconds, "w", 0, "<dummy>")
+	flag.Float64Var(&MaxIdleSeconds, "i", 0, "<dummy>")
+	flag.IntVar(&MaxMemory, "m", 64*1024*1024, "<dummy>")
+	flag.IntVar(&MaxFileSize, "f", 1024, "<dummy>")
+	flag.IntVar(&MaxOutput, "o", 64*1024*1024, "<dummy>")
+	flag.StringVar(&StderrFile, "e", "", "<dummy>")
+	flag.BoolVar(&AccusedMode, "a", false, "<dummy>")
+	flag.BoolVar(&SeccompMode, "s", false, "<dummy>")
+	flag.BoolVar(&Namespaces, "n", false, "<dummy>")
+	flag.StringVar(&CgroupParent, "g", "", "<dummy>")
+	flag.IntVar(&MaxPids, "p", 64, "<dummy>")
+	flag.IntVar(&SandboxUid, "u", -1, "<dummy>")
+	flag.StringVar(&CompileOutput, "c", "", "<dummy>")
+	flag.BoolVar(&JSONReport, "json", false, "<dummy>")
+	flag.StringVar(&PolicyFile, "P", "", "<dummy>")
+	flag.StringVar(&AuditFile, "A", "", "<dummy>")
+	flag.Parse()
+	args := flag.Args()
+	if CompileOutput != "" {
+		if len(args) < 2 {
+			fmt.Fprintf(os.Stderr, "Wrong number of arguments\n")
+			os.Exit(3)
+		}
+	} else if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "Wrong number of arguments\n")
+		os.Exit(3)
+	}
+	return args
+}
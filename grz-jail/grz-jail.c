@@ -1,3 +1,4 @@
+//go:build cgo
 
 /* 
    Garzón Jail                               (c) 2012, Pau Fernández
@@ -39,6 +40,142 @@
    string is in the list of permitted syscalls, only 'open' calls that
    have exactly the same path will succeed in "accused mode".
 
+   Child processes and threads (created with 'fork', 'vfork' or
+   'clone') are traced as well: their syscalls are recorded (or
+   checked) just as those of the main process, and all of them are
+   killed when the main process finishes or breaks some limit.
+
+   ABIs
+   ----
+
+   A process can make syscalls with an ABI other than that of its
+   executable (e.g. 'int 0x80' in a 64-bit x86 program makes i386
+   syscalls, with other numbers), so the ABI of each syscall is
+   checked (x86-64, x32 and i386 on x86-64, and aarch64). Any syscall
+   with an ABI different from that of the executable is forbidden
+   (even in model mode):
+
+       Execution Error
+       Forbidden Syscall ABI 'i386'
+
+   The representation of syscalls of a non-native ABI has its name
+   in front (e.g. 'i386:open("data")'), so that a 32-bit model only
+   matches a 32-bit accused. The tables of syscall names (syscalls.h)
+   are generated by mksyscalls.sh.
+
+   Policies
+   --------
+
+   Being too strict, the list of the model can be complemented with a
+   policy file (-P <file>, in accused mode), with lines like:
+
+       allow mmap(*)
+       allow open("*.txt")
+       deny  open("*passwd*")
+
+   The patterns are matched (with fnmatch) against the representation
+   of syscalls. A syscall is allowed if no 'deny' rule matches it,
+   and it is in the list of the model or some 'allow' rule matches it.
+   Empty lines and lines starting with '#' are ignored.
+
+   As with paths in the shell, '*', '?' and '[...]' never match a '/'
+   (e.g. 'open("*.txt")' doesn't match 'open("/etc/a.txt")'). An
+   'allow' rule never allows a filename with '..' or going through
+   the root or the working directory of a process in /proc (e.g.
+   '/proc/self/root/etc/passwd'), which lead anywhere, unless the
+   model made exactly the same syscall. With -g, the accused can also
+   open the 'cpu.max' of its cgroup (the Go runtime reads it).
+
+   Audit log
+   ---------
+
+   With -A <file>, the last AUDIT_LINES (traced) syscalls are written
+   to <file> at exit (so that its size is bounded), with the time since
+   the start (in seconds), the pid and its representation, and a
+   forbidden syscall is marked as such:
+
+       0.001 [1234] write(1,_,_)
+       0.002 [1234] open("D") FORBIDDEN
+
+   Seccomp mode
+   ------------
+
+   Stopping the accused twice for every syscall is slow for programs
+   which make lots of them (e.g. many small 'write's). With -s (in
+   accused mode), the list of syscalls is compiled into a seccomp-bpf
+   filter which lets the kernel allow, by number and integer
+   arguments, the syscalls of the list directly. Only syscalls with
+   filenames (and a few others that grz-jail must see, like 'exit' or
+   'brk') stop in ptrace to be checked as before, and the same goes
+   for any syscall which the filter doesn't allow. If the list is too
+   long for a filter (BPF_MAXINSNS instructions), the accused is
+   traced completely, as without -s.
+
+   Namespaces
+   ----------
+
+   With -n, the program runs in new user, mount, PID, network, IPC
+   and UTS namespaces. Its root is an empty read-only filesystem with
+   only <directory> (bind-mounted at '/eval', the working directory),
+   so that, even with a model that makes lots of syscalls, it cannot
+   see other files, processes or the network. Inside, the program is
+   'nobody' (which maps to the user running grz-jail), without any
+   capabilities. Model and accused must be run in the same way, since
+   paths (and pids) change.
+
+   Sandbox user
+   ------------
+
+   With -u <uid> (grz-jail must run as root), the program runs as
+   user and group <uid>, without supplementary groups, so that it
+   cannot read files of the user running the judge (e.g. the model,
+   or other submissions). <directory> must be accessible by <uid>.
+   With -n, 'nobody' inside maps to <uid> outside.
+
+   Time limits
+   -----------
+
+   The CPU time limit (-t) is given in seconds, with milliseconds
+   resolution (e.g. "-t 0.5"). Since a program which sleeps or waits
+   for input doesn't use CPU, there is also a wall time limit (-w, by
+   default twice the CPU limit plus one second), and, optionally, an
+   idleness limit (-i): the maximum time without using any CPU. A
+   timer checks these limits periodically, and the reports are "Time
+   Limit Exceeded", "Wall Time Limit Exceeded" and "Idleness Limit
+   Exceeded".
+
+   Output
+   ------
+
+   The standard output of the program is limited (-o, 64 MB by
+   default): beyond the limit, the report is "Output Limit Exceeded"
+   (instead of "Ok" or "Execution Error"). The standard error is
+   discarded, unless -e <file> is given, and then the first bytes
+   (up to the file size limit) are saved to <file>.
+
+   Cgroups
+   -------
+
+   With -g <parent>, the program runs in a new cgroup (v2) created
+   inside <parent>, which must be writable by the user of grz-jail
+   and have the 'memory', 'pids' and 'cpu' controllers enabled (in
+   its 'cgroup.subtree_control'). Then, the memory limit is the
+   resident memory ('memory.max', instead of the address space, which
+   is huge for Go or Java programs), the number of processes and
+   threads is limited (-p), and a single CPU is used. Memory and time
+   are read from the cgroup ('memory.peak', 'cpu.stat'), and an OOM
+   kill is reported as "Memory Limit Exceeded".
+
+   Compiler mode
+   -------------
+
+   grz-jail also has a "compiler mode" (-c <file>) in which no
+   syscalls are traced. It runs the command given after <directory>
+   (e.g. "g++ -static -o exe code.cc") inside <directory>, without
+   network access and with the same resource limits (including the
+   wall time limit). The output of the compiler goes to <file>, which
+   is subject to the file size limit.
+
    Exit status
    -----------
    0. Execution Ok (time + memory shown on stderr).
@@ -46,6 +183,20 @@
    2. Internal Error.
    3. Wrong Command Line.
 
+   With -json, the exit status is the same, but stderr has a report
+   in JSON (a single object), instead of lines of text:
+
+     {"status": "Execution Error", "reason": "Time Limit Exceeded",
+      "exit_code": 0, "signal": 0, "cpu_ms": 2003, "wall_ms": 2011,
+      "memory_kb": 1024, "peak_rss_kb": 780, "forbidden": "",
+      "syscalls": {"read": 1, ...}, "message": ""}
+
+   where "status" is "Ok", "Execution Error", "Non-Zero Status",
+   "Output Limit Exceeded" or "Internal Error" (explained in
+   "message"), "forbidden" is the
+   representation of a forbidden syscall, and "syscalls" has the
+   number of (traced) syscalls of each type.
+
    Acknowledgements
    ----------------
 
@@ -55,32 +206,71 @@
 */
 
 
+#define _GNU_SOURCE
 #define _LARGEFILE64_SOURCE
 #include <errno.h>
 #include <fcntl.h>
+#include <fnmatch.h>
+#include <grp.h>
+#include <limits.h>
+#include <poll.h>
+#include <pthread.h>
+#include <sched.h>
 #include <signal.h>
 #include <stdarg.h>
+#include <stddef.h>
 #include <stdint.h>
 #include <stdio.h>
 #include <stdlib.h>
 #include <string.h>
 #include <unistd.h>
+#include <linux/audit.h>
+#include <linux/filter.h>
+#include <linux/seccomp.h>
 #include <sys/prctl.h>
 #include <sys/ptrace.h>
 #include <sys/resource.h>
+#include <sys/mount.h>
 #include <sys/signal.h>
 #include <sys/stat.h>
+#include <sys/syscall.h>
 #include <sys/time.h>
 #include <sys/types.h>
 #include <sys/user.h>
 #include <sys/wait.h>
+#include <time.h>
+#ifdef __aarch64__
+#include <elf.h> // NT_PRSTATUS
+#include <sys/uio.h>
+#endif
+
+#ifndef PAGE_SIZE
+#define PAGE_SIZE 4096
+#endif
 
 const char *perm_file = ".syscalls";
+char *policy_file = NULL;
+char *audit_file = NULL;
+FILE *audit = NULL;
+#define AUDIT_LINES 100
+char *audit_lines[AUDIT_LINES]; // (a ring, written at exit)
+int audit_count = 0;
 int accused_mode = 0;
+int seccomp_mode = 0;
+int namespace_mode = 0;
+char *cgroup_parent = NULL; // cgroup mode if not NULL
+int json_report = 0;
+int max_pids = 64;
+int sandbox_uid = -1; // -1: the user of grz-jail
 int perm_fd = -1;
-int max_cpu_seconds = 2;
+int max_cpu_ms  = 2000;
+int max_wall_ms = 0; // 0: twice the CPU time plus one second
+int max_idle_ms = 0; // 0: no idleness limit
 int max_memory = 64 * 1024 * 1024;
 int max_file_size = 1024; // 1 Kbyte (for stderr)
+int max_output = 64 * 1024 * 1024; // stdout
+char *stderr_file = NULL; // stderr is discarded if NULL
+char *compile_output = NULL; // compiler mode if not NULL
 
 pid_t guardian_pid;
 
@@ -93,9 +283,21 @@ void usage_message(char *msg) {
       "\n"
       "Options:\n"
       "   -m <mem>   Max megabytes of memory\n"
-      "   -t <mem>   Max seconds\n"
+      "   -t <sec>   Max CPU seconds (e.g. 0.5)\n"
+      "   -w <sec>   Max wall clock seconds\n"
+      "   -i <sec>   Max seconds without using the CPU\n"
       "   -f <mem>   Max megabytes for files\n"
+      "   -o <bytes> Max bytes of standard output\n"
+      "   -e <file>  Save standard error to <file>\n"
       "   -a         Accused mode\n"
+      "   -P <file>  Policy file (with -a)\n"
+      "   -A <file>  Audit log of the last syscalls\n"
+      "   -s         Seccomp mode (with -a)\n"
+      "   -n         Run inside new namespaces\n"
+      "   -g <dir>   Run in a cgroup created inside <dir>\n"
+      "   -p <num>   Max processes and threads (with -g)\n"
+      "   -c <file>  Compiler mode (output to <file>)\n"
+      "   -json      Report in JSON\n"
       "\n";
    fprintf(stderr, "%s", _usage);
    exit(3);
@@ -104,12 +306,28 @@ void usage_message(char *msg) {
 #define FORMAT __attribute__((format(printf,2,3)))
 
 void kill_accused();
+void kill_compiler();
+void output_finish();
+void cgroup_remove();
+void audit_finish();
+void write_json_report(int code, char *msg);
 
 void FORMAT __die(int code, char *msg, ...) {
    kill_accused();
+   kill_compiler();
+   output_finish();
+   audit_finish();
    va_list args;
    va_start(args, msg);
-   vfprintf(stderr, msg, args);
+   if (json_report) {
+      char buf[8192];
+      vsnprintf(buf, sizeof(buf), msg, args);
+      write_json_report(code, buf); // (reads the cgroup)
+   } else {
+      vfprintf(stderr, msg, args);
+   }
+   va_end(args);
+   cgroup_remove();
    exit(code);
 }
 
@@ -122,6 +340,10 @@ void FORMAT __die(int code, char *msg, ...) {
 #define SYS(x) __NR_##x
 #define sizeof_array(A) (int)(sizeof(A)/sizeof(A[0]))
 
+inline int max_cpu_seconds() {
+   return (max_cpu_ms + 999) / 1000; // (the timer is more precise)
+}
+
 void setlimit(int what, rlim_t max) {
    if (max > 0) {
       struct rlimit L = { .rlim_cur = max, .rlim_max = max + 1 };
@@ -155,112 +377,171 @@ void read_proc_status(pid_t pid, char *buf) {
    read_proc_file(pid, buf, "status", &proc_status_fd);
 }
 
-int read_user_mem(pid_t pid, uint64_t addr, char *buf, int len) {
-   /* Taken from box.c almost unchanged */
-   static int mem_fd;
-   if (!mem_fd) {
-      char memname[64];
-      sprintf(memname, "/proc/%d/mem", (int)pid);
-      mem_fd = open(memname, O_RDONLY);
-      if (mem_fd < 0)
-         die("open(%s): %m", memname);
-   }
-   if (lseek(mem_fd, addr, SEEK_SET) < 0) {
-      die("lseek(mem): %m");
-   }
-   return read(mem_fd, buf, len);
+void read_proc_stat(pid_t pid, char *buf) {
+   static int proc_stat_fd;
+   read_proc_file(pid, buf, "stat", &proc_stat_fd);
 }
 
-// syscall tables
+/** Syscall tables **/
 
-#define __SYSCALL(a, b) [a] = #b,
-   const char *_syscall_names[] = {
-#include <asm/unistd.h>
-   };
-#undef __SYSCALL
+/*
+   The same number is a different syscall in each ABI, and a process
+   can use more than one: a 64-bit x86 program can make i386 syscalls
+   (with 'int 0x80') or x32 ones (setting __X32_SYSCALL_BIT in the
+   number). So the syscalls are named with the table of their ABI
+   (see mksyscalls.sh), and the ABI of each stop is checked.
+*/
+
+#include "syscalls.h"
+
+#define X32_SYSCALL_BIT 0x40000000
+#define MAX_SYSCALLS    1024
+
+typedef struct _Abi {
+   const char *name;
+   const char **names;
+   int num;
+   uint32_t audit_arch;
+} Abi;
+
+enum { ABI_X86_64, ABI_X32, ABI_I386, ABI_AARCH64, ABI_UNKNOWN, NUM_ABIS };
 
-inline const char *syscall_name(unsigned int id) {
-   if (id < sizeof_array(_syscall_names)) {
-      return _syscall_names[id] + 4; // +4 to remove "sys_"
+#define ABI(name, arch) { #name, name##_syscalls, sizeof_array(name##_syscalls), arch }
+Abi abis[NUM_ABIS] = {
+   [ABI_X86_64]  = ABI(x86_64,  AUDIT_ARCH_X86_64),
+   [ABI_X32]     = ABI(x32,     AUDIT_ARCH_X86_64),
+   [ABI_I386]    = ABI(i386,    AUDIT_ARCH_I386),
+   [ABI_AARCH64] = ABI(aarch64, AUDIT_ARCH_AARCH64),
+   [ABI_UNKNOWN] = { "unknown", NULL, 0, 0 },
+};
+#undef ABI
+
+#if defined(__x86_64__)
+#define NATIVE_ABI ABI_X86_64
+#elif defined(__aarch64__)
+#define NATIVE_ABI ABI_AARCH64
+#else
+#error "grz-jail runs only on x86-64 and aarch64"
+#endif
+
+int expected_abi = NATIVE_ABI; // the ABI of the executable
+
+const char *syscall_name(int abi, uint64_t nr) {
+   if (nr < abis[abi].num) {
+      return abis[abi].names[nr];
    } else {
       return NULL;
    }
 }
 
-#define NUM_SYSCALLS sizeof_array(_syscall_names)
+typedef struct _ArgTypes {
+   const char *name, *types;
+} ArgTypes;
 
-static const char* _syscall_arg_types[NUM_SYSCALLS + 64] = { // +64?
+static const ArgTypes _syscall_arg_types[] = {
    // i  integer (long)
    // f  filename
    // .  ignore
    // *  ignore all starting from this one.
-#define S(x) [__NR_##x]
+
    // Syscalls with filenames in them
-   S(open)     = "f*",
-   S(creat)    = "f*",
-   S(unlink)   = "f",
-   S(access)   = "f*", 
-   S(truncate) = "f*",
-   S(stat)     = "f*",
-   S(lstat)    = "f*",
-   S(readlink) = "f*",
-   S(chmod)    = "fi",
+   { "open",              "f*" },
+   { "creat",             "f*" },
+   { "unlink",            "f" },
+   { "access",            "f*" },
+   { "truncate",          "f*" },
+   { "stat",              "f*" },
+   { "lstat",             "f*" },
+   { "readlink",          "f*" },
+   { "chmod",             "fi" },
 
    // Syscalls with file descriptors
-   S(read)      = "i..",
-   S(write)     = "i..",
-   S(close)     = "i",
-   S(lseek)     = "i..",
-   S(dup)       = "i",
-   S(dup2)      = "ii",
-   S(ftruncate) = "i.",
-   S(fstat)     = "i.",
-   S(readv)     = "i..",
-   S(writev)    = "i..",
-   S(pread64)   = "i...",
-   S(pwrite64)  = "i...",
-   S(fcntl)     = "ii*",
-   S(ioctl)     = "ii",
-   S(fchmod)    = "ii",
+   { "read",              "i.." },
+   { "write",             "i.." },
+   { "close",             "i" },
+   { "lseek",             "i.." },
+   { "dup",               "i" },
+   { "dup2",              "ii" },
+   { "ftruncate",         "i." },
+   { "fstat",             "i." },
+   { "readv",             "i.." },
+   { "writev",            "i.." },
+   { "pread64",           "i..." },
+   { "pwrite64",          "i..." },
+   { "fcntl",             "ii*" },
+   { "ioctl",             "ii" },
+   { "fchmod",            "ii" },
 
    // Others
-   S(exit)            = "i",
-   S(exit_group)      = "i",
-   S(arch_prctl)      = "i.",
-   S(getpid)          = "",
-   S(getuid)          = "",
-   S(brk)             = ".",
-   S(personality)     = "i",
-   S(getresuid)       = "*",
-   S(mmap)            = "*",
-   S(munmap)          = "*",
-   S(uname)           = ".",
-   S(gettid)          = "",
-   S(set_thread_area) = ".",
-   S(get_thread_area) = ".",
-   S(set_tid_address) = ".",
-   S(time)            = ".",
-   S(alarm)           = "i",
-   S(pause)           = "",
-   S(nanosleep)       = "*",
+   { "exit",              "i" },
+   { "exit_group",        "i" },
+   { "arch_prctl",        "i." },
+   { "getpid",            "" },
+   { "getuid",            "" },
+   { "brk",               "." },
+   { "personality",       "i" },
+   { "getresuid",         "*" },
+   { "mmap",              "*" },
+   { "munmap",            "*" },
+   { "uname",             "." },
+   { "gettid",            "" },
+   { "set_thread_area",   "." },
+   { "get_thread_area",   "." },
+   { "set_tid_address",   "." },
+   { "time",              "." },
+   { "alarm",             "i" },
+   { "pause",             "" },
+   { "nanosleep",         "*" },
 
    // Go
-   S(getrlimit)       = "i.i",
-   S(rt_sigprocmask)  = "i..",
-   S(rt_sigaction)    = "i.i",
-   S(gettimeofday)    = ".i.",
-   S(sigaltstack)     = ".ii",
-   S(clone)           = "*",
-   S(futex)           = ".ii",
-#undef S
+   { "getrlimit",         "i.i" },
+   { "rt_sigprocmask",    "i.." },
+   { "rt_sigaction",      "i*" },
+   { "gettimeofday",      ".i." },
+   { "sigaltstack",       "*" },
+   { "clone",             "*" },
+   { "futex",             ".ii" },
+
+   // Threads & newer libc
+   { "clone3",            "*" },
+   { "set_robust_list",   ".." },
+   { "rseq",              "*" },
+   { "sched_getaffinity", "i*" },
+   { "sched_yield",       "" },
+   { "tgkill",            "..i" },
+   { "prctl",             "i*" },
+   { "madvise",           "*" },
+   { "mprotect",          "*" },
+   { "getrandom",         ".ii" },
+   { "prlimit64",         "ii*" },
+   { "wait4",             "i*" },
+   { "clock_gettime",     "i." },
+   { "rt_sigreturn",      "" },
+   { "openat",            "if*" },
+   { "newfstatat",        "if*" },
+   { "readlinkat",        "if*" },
+
+   // i386
+   { "mmap2",             "*" },
+   { "_llseek",           "i*" },
+   { "fstat64",           "i." },
+   { "stat64",            "f*" },
+   { "lstat64",           "f*" },
+   { "fcntl64",           "ii*" },
+   { "ugetrlimit",        "i." },
 };
 
-inline const char *syscall_arg_types(unsigned int id) {
-   if (id < sizeof_array(_syscall_arg_types)) {
-      return _syscall_arg_types[id];
-   } else {
+const char *syscall_arg_types(const char *name) {
+   int i;
+   if (name == NULL) {
       return NULL;
    }
+   for (i = 0; i < sizeof_array(_syscall_arg_types); i++) {
+      if (!strcmp(_syscall_arg_types[i].name, name)) {
+         return _syscall_arg_types[i].types;
+      }
+   }
+   return NULL;
 }
 
 /** List of syscalls **/
@@ -313,33 +594,668 @@ int syscall_list_find(const char *repr) {
    return 0;
 }
 
+/** Policy **/
+
+typedef struct _Rule {
+   int allow;
+   const char *pattern;
+   struct _Rule *next;
+} Rule;
+
+Rule *rules = NULL, *last_rule = NULL;
+
+void policy_add(int allow, const char *pattern) {
+   Rule *R = malloc(sizeof(Rule));
+   die_if(R == NULL, "Out of memory\n");
+   R->allow = allow;
+   R->pattern = strdup(pattern);
+   die_if(R->pattern == NULL, "Out of memory\n");
+   R->next = NULL;
+   if (rules == NULL) {
+      rules = last_rule = R;
+   } else {
+      last_rule->next = R;
+      last_rule = R;
+   }
+}
+
+void policy_read() {
+   FILE *F = fopen(policy_file, "r");
+   die_if(F == NULL, "Cannot read file '%s': %s\n", policy_file, strerror(errno));
+   size_t n = 0;
+   char *line = NULL;
+   int num = 0;
+   while (-1 != getline(&line, &n, F)) {
+      num++;
+      char *p = line, *end = line + strlen(line);
+      while (end > p && (end[-1] == '\n' || end[-1] == ' ' || end[-1] == '\t')) *--end = 0;
+      while (*p == ' ' || *p == '\t') p++;
+      if (*p == 0 || *p == '#') continue;
+
+      int allow;
+      if (!strncmp(p, "allow", 5) && (p[5] == ' ' || p[5] == '\t')) {
+         allow = 1;
+         p += 5;
+      } else if (!strncmp(p, "deny", 4) && (p[4] == ' ' || p[4] == '\t')) {
+         allow = 0;
+         p += 4;
+      } else {
+         die("%s:%d: expected 'allow' or 'deny'\n", policy_file, num);
+      }
+      while (*p == ' ' || *p == '\t') p++;
+      policy_add(allow, p);
+   }
+   free(line);
+   fclose(F);
+}
+
+int policy_match(int allow, const char *repr) {
+   Rule *R;
+   for (R = rules; R != NULL; R = R->next) {
+      if (R->allow == allow && fnmatch(R->pattern, repr, FNM_PATHNAME) == 0) {
+         return 1;
+      }
+   }
+   return 0;
+}
+
+/*
+   A filename with '..', or through the root or working directory of
+   a process in /proc, can lead anywhere, so no pattern allows it.
+*/
+int unsafe_path(const char *repr) {
+   if (strstr(repr, "..") != NULL) {
+      return 1;
+   }
+   if (strstr(repr, "/proc/") == NULL) {
+      return 0;
+   }
+   return strstr(repr, "/root/") || strstr(repr, "/root\"") ||
+          strstr(repr, "/cwd/") || strstr(repr, "/cwd\"");
+}
+
+int syscall_allowed(const char *repr) {
+   if (policy_match(0, repr)) {
+      return 0;
+   }
+   return syscall_list_find(repr) || (!unsafe_path(repr) && policy_match(1, repr));
+}
+
+/** Seccomp **/
+
+// Syscalls that always stop in ptrace, since grz-jail needs to see
+// them (exec to start, exit to sample memory, ENOMEM detection).
+const int seccomp_traced[] = {
+   SYS(execve), SYS(exit), SYS(exit_group),
+   SYS(brk), SYS(mmap), SYS(mremap),
+};
+
+// (the filter has only syscalls of the native ABI)
+int syscall_number(const char *name, int len) {
+   int i;
+   for (i = 0; i < abis[NATIVE_ABI].num; i++) {
+      const char *n = syscall_name(NATIVE_ABI, i);
+      if (n != NULL && (int)strlen(n) == len && !strncmp(n, name, len)) {
+         return i;
+      }
+   }
+   return -1;
+}
+
+#define SECCOMP_ARG(i) (offsetof(struct seccomp_data, args) + 8*(i))
+#define STMT(...)      ((struct sock_filter) BPF_STMT(__VA_ARGS__))
+#define JUMP(...)      ((struct sock_filter) BPF_JUMP(__VA_ARGS__))
+#define LOAD(off)      STMT(BPF_LD | BPF_W | BPF_ABS, (off))
+#define RET(val)       STMT(BPF_RET | BPF_K, (val))
+#define JEQ(val, skip) JUMP(BPF_JMP | BPF_JEQ | BPF_K, (val), 0, (skip))
+
+struct sock_filter filter[BPF_MAXINSNS];
+int filter_len = 0;
+int filter_overflow = 0; // (too many instructions)
+
+void filter_add(struct sock_filter insn) {
+   if (filter_len >= BPF_MAXINSNS) {
+      filter_overflow = 1;
+      return;
+   }
+   filter[filter_len++] = insn;
+}
+
+/*
+   Adds the checks for a syscall representation: the number and the
+   integer arguments must be equal, otherwise the check jumps to the
+   next one. Syscalls that cannot be checked without looking into
+   the memory of the process (filenames) are left for ptrace.
+*/
+void filter_add_syscall(const char *repr) {
+   const char *paren = strchr(repr, '(');
+   if (paren == NULL) return;
+   int nr = syscall_number(repr, paren - repr);
+   if (nr < 0) return;
+   int i;
+   for (i = 0; i < sizeof_array(seccomp_traced); i++) {
+      if (seccomp_traced[i] == nr) return;
+   }
+   const char *types = syscall_arg_types(syscall_name(NATIVE_ABI, nr));
+   if (types == NULL || strchr(types, 'f') != NULL) return;
+
+   uint64_t val[3];
+   int idx[3], nargs = 0;
+   const char *p = paren + 1;
+   for (i = 0; types[i] && types[i] != '*' && *p && *p != ')'; i++) {
+      if (types[i] == 'i') {
+         char *end;
+         idx[nargs] = i;
+         val[nargs++] = strtoull(p, &end, 10);
+         p = end;
+      } else {
+         while (*p && *p != ',' && *p != ')') p++;
+      }
+      if (*p == ',') p++;
+   }
+
+   int len = 3 + 4*nargs, start = filter_len;
+   #define SKIP (start + len - filter_len - 1)
+   filter_add(LOAD(offsetof(struct seccomp_data, nr)));
+   filter_add(JEQ(nr, SKIP));
+   for (i = 0; i < nargs; i++) {
+      filter_add(LOAD(SECCOMP_ARG(idx[i])));
+      filter_add(JEQ((uint32_t)val[i], SKIP));
+      filter_add(LOAD(SECCOMP_ARG(idx[i]) + 4));
+      filter_add(JEQ((uint32_t)(val[i] >> 32), SKIP));
+   }
+   #undef SKIP
+   filter_add(RET(SECCOMP_RET_ALLOW));
+}
+
+// In the guardian (before the fork), so that seccomp mode can be
+// turned off if the filter doesn't fit
+void seccomp_build() {
+   filter_len = 0;
+   filter_overflow = 0;
+   filter_add(LOAD(offsetof(struct seccomp_data, arch)));
+   filter_add(JUMP(BPF_JMP | BPF_JEQ | BPF_K, abis[NATIVE_ABI].audit_arch, 1, 0));
+   filter_add(RET(SECCOMP_RET_TRACE)); // (another ABI, forbidden in ptrace)
+   Node *curr;
+   for (curr = first; curr != NULL; curr = curr->next) {
+      if (!policy_match(0, curr->repr)) { // (denied ones are traced)
+         filter_add_syscall(curr->repr);
+      }
+   }
+   filter_add(RET(SECCOMP_RET_TRACE));
+   if (filter_overflow) {
+      seccomp_mode = 0; // (trace everything)
+   }
+}
+
+// In the accused
+void seccomp_install() {
+   struct sock_fprog prog = { .len = filter_len, .filter = filter };
+   die_if(prctl(PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0) < 0,
+          "prctl(PR_SET_NO_NEW_PRIVS): %s\n", strerror(errno));
+   die_if(prctl(PR_SET_SECCOMP, SECCOMP_MODE_FILTER, &prog) < 0,
+          "prctl(PR_SET_SECCOMP): %s\n", strerror(errno));
+}
+
+#undef SECCOMP_ARG
+#undef STMT
+#undef JUMP
+#undef LOAD
+#undef RET
+#undef JEQ
+
+/** Namespaces **/
+
+const int namespace_flags = 
+   CLONE_NEWUSER | CLONE_NEWNS | CLONE_NEWPID | 
+   CLONE_NEWNET | CLONE_NEWIPC | CLONE_NEWUTS;
+
+uid_t outer_uid;
+gid_t outer_gid;
+
+void write_proc_self(const char *name, const char *fmt, ...) {
+   char path[64], buf[64];
+   va_list args;
+   va_start(args, fmt);
+   int len = vsnprintf(buf, sizeof(buf), fmt, args);
+   va_end(args);
+   sprintf(path, "/proc/self/%s", name);
+   int fd = open(path, O_WRONLY);
+   die_if(fd < 0, "open(%s): %s\n", path, strerror(errno));
+   die_if(write(fd, buf, len) != len, "write(%s): %s\n", path, strerror(errno));
+   close(fd);
+}
+
+/*
+   Builds the new root (in the new mount namespace): a tmpfs mounted
+   over <directory> itself, with the original <directory> bind-mounted
+   at '/eval', and then pivots to it (the executable is then
+   '/eval/exe').
+*/
+void isolate_accused(char *dir) {
+   // 'nobody' inside is us outside
+   if (sandbox_uid >= 0) { // (changing the euid made /proc/self root's)
+      die_if(prctl(PR_SET_DUMPABLE, 1) < 0, "prctl(PR_SET_DUMPABLE): %s\n", strerror(errno));
+   }
+   write_proc_self("setgroups", "deny");
+   write_proc_self("uid_map", "65534 %d 1", (int)outer_uid);
+   write_proc_self("gid_map", "65534 %d 1", (int)outer_gid);
+   if (sandbox_uid >= 0) { // (the real ids are still root's)
+      die_if(setresgid(65534, 65534, 65534) < 0, "setresgid: %s\n", strerror(errno));
+      die_if(setresuid(65534, 65534, 65534) < 0, "setresuid: %s\n", strerror(errno));
+   }
+
+   char root[PATH_MAX], path[PATH_MAX + 64];
+   die_if(realpath(dir, root) == NULL, "realpath(\"%s\"): %s\n", dir, strerror(errno));
+   int dirfd = open(root, O_PATH | O_DIRECTORY);
+   die_if(dirfd < 0, "open(\"%s\"): %s\n", root, strerror(errno));
+
+   die_if(mount(NULL, "/", NULL, MS_REC | MS_PRIVATE, NULL) < 0,
+          "mount(MS_PRIVATE): %s\n", strerror(errno));
+   die_if(mount("grz-jail", root, "tmpfs", MS_NOSUID | MS_NODEV, "size=64k,mode=755") < 0,
+          "mount(tmpfs): %s\n", strerror(errno));
+   sprintf(path, "%s/eval", root);
+   die_if(mkdir(path, 0755) < 0, "mkdir(\"%s\"): %s\n", path, strerror(errno));
+   char fdpath[64];
+   sprintf(fdpath, "/proc/self/fd/%d", dirfd);
+   die_if(mount(fdpath, path, NULL, MS_BIND | MS_NOSUID | MS_NODEV, NULL) < 0,
+          "mount(MS_BIND): %s\n", strerror(errno));
+   close(dirfd);
+   sprintf(path, "%s/.old", root);
+   die_if(mkdir(path, 0755) < 0, "mkdir(\"%s\"): %s\n", path, strerror(errno));
+
+   die_if(chdir(root) < 0, "chdir(\"%s\"): %s\n", root, strerror(errno));
+   die_if(syscall(SYS_pivot_root, ".", ".old") < 0, "pivot_root: %s\n", strerror(errno));
+   die_if(chdir("/") < 0, "chdir(\"/\"): %s\n", strerror(errno));
+   die_if(umount2("/.old", MNT_DETACH) < 0, "umount(\"/.old\"): %s\n", strerror(errno));
+   rmdir("/.old");
+   die_if(mount(NULL, "/", NULL, MS_REMOUNT | MS_RDONLY | MS_NOSUID | MS_NODEV, NULL) < 0,
+          "mount(MS_RDONLY): %s\n", strerror(errno));
+   die_if(chdir("/eval") < 0, "chdir(\"/eval\"): %s\n", strerror(errno));
+}
+
+/*
+   Drops to the sandbox user (in the accused, without namespaces).
+*/
+void drop_privileges() {
+   die_if(setgroups(0, NULL) < 0, "setgroups: %s\n", strerror(errno));
+   die_if(setresgid(sandbox_uid, sandbox_uid, sandbox_uid) < 0, 
+          "setresgid(%d): %s\n", sandbox_uid, strerror(errno));
+   die_if(setresuid(sandbox_uid, sandbox_uid, sandbox_uid) < 0, 
+          "setresuid(%d): %s\n", sandbox_uid, strerror(errno));
+}
+
+/** Cgroups **/
+
+char cgroup_dir[PATH_MAX] = "";
+
+int cgroup_open(const char *file, int flags) {
+   char path[PATH_MAX + 64];
+   sprintf(path, "%s/%s", cgroup_dir, file);
+   return open(path, flags);
+}
+
+void cgroup_write(const char *file, const char *fmt, ...) {
+   char buf[64];
+   va_list args;
+   va_start(args, fmt);
+   int len = vsnprintf(buf, sizeof(buf), fmt, args);
+   va_end(args);
+   int fd = cgroup_open(file, O_WRONLY);
+   die_if(fd < 0, "cgroup: open(\"%s\"): %s\n", file, strerror(errno));
+   die_if(write(fd, buf, len) != len, "cgroup: write(\"%s\"): %s\n", file, strerror(errno));
+   close(fd);
+}
+
+// Returns the value of 'key' in a cgroup file (if 'key' is NULL,
+// the file has only a value), or -1 if the file is not there.
+long long cgroup_read(const char *file, const char *key) {
+   char buf[PROC_BUF_SIZE];
+   int fd = cgroup_open(file, O_RDONLY);
+   if (fd < 0) {
+      return -1;
+   }
+   int c = read(fd, buf, PROC_BUF_SIZE-1);
+   close(fd);
+   if (c < 0) {
+      return -1;
+   }
+   buf[c] = 0;
+   if (key == NULL) {
+      return atoll(buf);
+   }
+   int len = strlen(key);
+   char *line = buf;
+   while (line != NULL && *line) {
+      if (!strncmp(line, key, len) && line[len] == ' ') {
+         return atoll(line + len + 1);
+      }
+      line = strchr(line, '\n');
+      if (line != NULL) line++;
+   }
+   return -1;
+}
+
+void cgroup_create() {
+   sprintf(cgroup_dir, "%s/grz-jail-%d", cgroup_parent, (int)getpid());
+   if (mkdir(cgroup_dir, 0755) < 0) {
+      int err = errno;
+      cgroup_dir[0] = 0; // not ours
+      die("cgroup: mkdir(\"%s/grz-jail-%d\"): %s\n", 
+          cgroup_parent, (int)getpid(), strerror(err));
+   }
+   cgroup_write("memory.max", "%d", max_memory);
+   cgroup_write("pids.max", "%d", max_pids);
+   cgroup_write("cpu.max", "100000 100000"); // one CPU
+   int fd = cgroup_open("memory.swap.max", O_WRONLY);
+   if (fd >= 0) { // (only with swap accounting)
+      die_if(write(fd, "0", 1) != 1, "cgroup: write(\"memory.swap.max\")\n");
+      close(fd);
+   }
+}
+
+void cgroup_add(pid_t pid) {
+   cgroup_write("cgroup.procs", "%d", (int)pid);
+}
+
+void cgroup_remove() {
+   if (cgroup_dir[0] == 0) {
+      return;
+   }
+   // Processes (all traced, thus killed) leave the cgroup
+   // asynchronously
+   int i;
+   for (i = 0; i < 100 && rmdir(cgroup_dir) < 0 && errno == EBUSY; i++) {
+      usleep(10000);
+   }
+   cgroup_dir[0] = 0;
+}
+
+int cgroup_oom_killed() {
+   return cgroup_dir[0] != 0 && cgroup_read("memory.events", "oom_kill") > 0;
+}
+
+/** Output **/
+
+/*
+   The standard output (and error, with -e) of the accused goes
+   through pipes to a thread of the guardian, so that it can be
+   limited: the output is forwarded until it has more than -o bytes
+   (then the accused gets "Output Limit Exceeded"), and the error is
+   saved to a file, up to the file size limit (the rest is
+   discarded).
+*/
+
+int out_pipe[2] = { -1, -1 };
+int err_pipe[2] = { -1, -1 };
+int err_fd = -1;
+long long output_bytes = 0, error_bytes = 0;
+volatile sig_atomic_t output_exceeded = 0;
+pthread_t output_thread;
+int output_started = 0;
+
+void output_create() {
+   die_if(pipe2(out_pipe, O_CLOEXEC) < 0, "pipe2: %s\n", strerror(errno));
+   if (stderr_file != NULL) {
+      die_if(pipe2(err_pipe, O_CLOEXEC) < 0, "pipe2: %s\n", strerror(errno));
+      err_fd = open(stderr_file, O_WRONLY | O_CREAT | O_TRUNC | O_CLOEXEC, 0600);
+      die_if(err_fd < 0, "open(\"%s\"): %s\n", stderr_file, strerror(errno));
+   }
+}
+
+// Writes what fits below 'max' bytes, returns 0 if some didn't fit
+int write_bounded(int fd, char *buf, int n, long long *total, long long max) {
+   int fits = n;
+   if (*total + n > max) {
+      fits = (*total < max ? max - *total : 0);
+   }
+   int done = 0;
+   while (done < fits) {
+      int w = write(fd, buf + done, fits - done);
+      if (w < 0 && errno == EINTR) continue;
+      if (w <= 0) break; // (the reader is gone)
+      done += w;
+   }
+   *total += n;
+   return fits == n;
+}
+
+void *output_forward(void *arg) {
+   struct pollfd fds[2] = {
+      { .fd = out_pipe[0], .events = POLLIN },
+      { .fd = err_pipe[0], .events = POLLIN }, // (ignored if -1)
+   };
+   int i, open = (err_pipe[0] >= 0 ? 2 : 1);
+   static char buf[65536];
+   while (open > 0) {
+      if (poll(fds, 2, -1) < 0) {
+         if (errno == EINTR) continue;
+         break;
+      }
+      for (i = 0; i < 2; i++) {
+         if (fds[i].fd < 0 || fds[i].revents == 0) continue;
+         int n = read(fds[i].fd, buf, sizeof(buf));
+         if (n < 0 && errno == EINTR) continue;
+         if (n <= 0) { // all writers closed it
+            fds[i].fd = -1;
+            open--;
+         } else if (i == 0) {
+            // (after the limit, the output is drained and discarded)
+            if (!write_bounded(1, buf, n, &output_bytes, max_output)) {
+               output_exceeded = 1;
+            }
+         } else {
+            write_bounded(err_fd, buf, n, &error_bytes, max_file_size);
+         }
+      }
+   }
+   return NULL;
+}
+
+// In the accused (the pipes are closed at exec)
+void output_redirect() {
+   die_if(dup2(out_pipe[1], 1) < 0, "Redirect stdout to a pipe\n");
+   if (err_pipe[1] >= 0) {
+      die_if(dup2(err_pipe[1], 2) < 0, "Redirect stderr to a pipe\n");
+   }
+}
+
+// In the guardian
+void output_start() {
+   close(out_pipe[1]);
+   if (err_pipe[1] >= 0) {
+      close(err_pipe[1]);
+   }
+   int ret = pthread_create(&output_thread, NULL, output_forward, NULL);
+   die_if(ret != 0, "pthread_create: %s\n", strerror(ret));
+   output_started = 1;
+}
+
+// Waits until the output of the (dead) accused is forwarded
+void output_finish() {
+   if (!output_started) {
+      return;
+   }
+   output_started = 0;
+   struct timespec limit;
+   clock_gettime(CLOCK_REALTIME, &limit);
+   limit.tv_sec += 1; // (in case some process still has the pipe)
+   pthread_timedjoin_np(output_thread, NULL, &limit);
+}
+
+void output_check() {
+   if (output_exceeded) {
+      report_failure("Output Limit Exceeded\nMore than %d bytes\n", max_output);
+   }
+}
+
+/** Audit log **/
+
+__attribute__((format(printf,1,2)))
+void audit_add(const char *fmt, ...) {
+   char *line = NULL;
+   va_list args;
+   va_start(args, fmt);
+   int ret = vasprintf(&line, fmt, args);
+   va_end(args);
+   if (ret < 0) {
+      return;
+   }
+   int i = audit_count++ % AUDIT_LINES;
+   free(audit_lines[i]); // (the oldest)
+   audit_lines[i] = line;
+}
+
+// Writes the last lines in order
+void audit_finish() {
+   if (audit == NULL) {
+      return;
+   }
+   int first = (audit_count > AUDIT_LINES ? audit_count - AUDIT_LINES : 0);
+   for (int k = first; k < audit_count; k++) {
+      fputs(audit_lines[k % AUDIT_LINES], audit);
+   }
+   fclose(audit);
+   audit = NULL;
+}
+
 /** Accused **/
 
-pid_t accused_pid[2] = {0, 0};
+pid_t accused_pid = 0; // the main process of the accused
+int   accused_exit_code = 0;
+int   accused_signal = 0;
+char *accused_forbidden = NULL; // forbidden syscall
+int   syscall_counts[NUM_ABIS][MAX_SYSCALLS];
 int   passed_exec = 0;
 int   accused_mem_peak_kb = 0;
 struct timeval start_time;
 struct rusage usage;
-struct user user;
+
+inline void get_start_time() {
+   gettimeofday(&start_time, NULL);
+}
+
+int ellapsed_time_ms() {
+   struct timeval now, wall;
+   gettimeofday(&now, NULL);
+   timersub(&now, &start_time, &wall);
+   return wall.tv_sec * 1000 + wall.tv_usec/1000;
+}
 
 typedef struct _syscall_args {
+   int abi;
+   const char *name; // NULL if unknown
    uint64_t sys, arg[4], result;
 } syscall_args;
 
+/*
+   Tracees are the accused and all the processes and threads it
+   creates (with fork, vfork or clone), which are traced as well
+   (PTRACE_O_TRACEFORK, etc.), so the same syscall filter applies to
+   all of them.
+*/
+
+#define MAX_TRACEES 256
+
+typedef struct _Tracee {
+   pid_t pid;
+   int   started;    // has had its initial SIGSTOP
+   int   in_syscall; // between syscall entry and exit
+   int   curr_sys;
+   int   mem_fd;     // for /proc/<pid>/mem
+} Tracee;
+
+Tracee tracees[MAX_TRACEES];
+int num_tracees = 0;
+
+Tracee *tracee_find(pid_t pid) {
+   int i;
+   for (i = 0; i < num_tracees; i++) {
+      if (tracees[i].pid == pid) {
+         return &tracees[i];
+      }
+   }
+   return NULL;
+}
+
+Tracee *tracee_add(pid_t pid) {
+   Tracee *T = tracee_find(pid);
+   if (T != NULL) {
+      return T;
+   }
+   if (num_tracees >= MAX_TRACEES) {
+      kill(pid, SIGKILL);
+      report_execerror("Too Many Processes");
+   }
+   T = &tracees[num_tracees++];
+   T->pid = pid;
+   T->started = 0;
+   T->in_syscall = 0;
+   T->curr_sys = -1;
+   T->mem_fd = -1;
+   return T;
+}
+
+void tracee_remove(pid_t pid) {
+   Tracee *T = tracee_find(pid);
+   if (T != NULL) {
+      if (T->mem_fd >= 0) {
+         close(T->mem_fd);
+      }
+      *T = tracees[--num_tracees];
+   }
+}
+
+void kill_tracees() {
+   int i;
+   for (i = 0; i < num_tracees; i++) {
+      kill(tracees[i].pid, SIGKILL);
+   }
+}
+
+int read_user_mem(Tracee *T, uint64_t addr, char *buf, int len) {
+   /* Taken from box.c almost unchanged */
+   if (T->mem_fd < 0) {
+      char memname[64];
+      sprintf(memname, "/proc/%d/mem", (int)T->pid);
+      T->mem_fd = open(memname, O_RDONLY);
+      if (T->mem_fd < 0)
+         die("open(%s): %m", memname);
+   }
+   if (lseek(T->mem_fd, addr, SEEK_SET) < 0) {
+      die("lseek(mem): %m");
+   }
+   return read(T->mem_fd, buf, len);
+}
 
 char exename[1024];
 
 void the_accused(char *dir) {
-   setlimit(RLIMIT_CPU,   max_cpu_seconds);
-   setlimit(RLIMIT_AS,    max_memory);
+   int null = open("/dev/null", O_WRONLY | O_APPEND);
+   die_if(null < 0, "open(\"/dev/null\"): %s\n", strerror(errno));
+   if (namespace_mode) {
+      isolate_accused(dir);
+      strcpy(exename, "/eval/exe");
+   }
+   setlimit(RLIMIT_CPU,   max_cpu_seconds());
+   if (cgroup_parent == NULL) { // (otherwise 'memory.max')
+      setlimit(RLIMIT_AS, max_memory);
+   }
    setlimit(RLIMIT_FSIZE, max_file_size);
+   if (sandbox_uid >= 0 && !namespace_mode) { // (see isolate_accused)
+      drop_privileges();
+   }
    die_if(ptrace(PTRACE_TRACEME) < 0, "ptrace(PTRACE_TRACEME)\n");
    // redirect stderr (has FSIZE limits!)
-   close(2);
-   if (2 != open("/dev/null", O_WRONLY | O_APPEND)) {
+   if (dup2(null, 2) < 0) {
       die("Redirect stderr to '/dev/null'\n");
    }
+   close(null);
+   output_redirect();
    raise(SIGSTOP);
+   if (seccomp_mode) {
+      seccomp_install(); // after SIGSTOP, when the options are set
+   }
    char *argv[] = { NULL };
    char *env[] =  { NULL };
    execve(exename, argv, env);
@@ -357,7 +1273,7 @@ void accused_sample_mem_peak() {
     *  that the process is about to exit.
     */
    char buf[PROC_BUF_SIZE], *x;
-   read_proc_status(accused_pid[0], buf);
+   read_proc_status(accused_pid, buf);
    
    x = buf;
    while (*x) {
@@ -378,23 +1294,18 @@ void accused_sample_mem_peak() {
    }
 }
 
-int wait_for_accused(int *stat) {
-   pid_t p = wait4(accused_pid[0], stat, WUNTRACED, &usage);
-   if (p < 0 && errno == EINTR) return 1;
-   die_if(p < 0, "wait4 error %d\n", errno);
-   die_if(p != accused_pid[0], "wait4: unknown pid '%d'\n", p);
-   return 0;
-}
-
 void kill_accused() {
-   if (accused_pid[0] > 0) {
+   if (accused_pid > 0) {
       accused_sample_mem_peak();
-      ptrace(PTRACE_KILL, accused_pid[0]);
+      kill_tracees();
+      // Reap everything (the main thread is the last one)
       int p, stat;
       do {
-         p = wait4(accused_pid[0], &stat, 0, &usage);
-      } while (p < 0 && errno == EINTR);
-      die_if(p < 0, "Lost track of the accused!");
+         p = wait4(-1, &stat, __WALL, &usage);
+      } while ((p < 0 && errno == EINTR) ||
+               (p > 0 && (p != accused_pid || WIFSTOPPED(stat))));
+      die_if(p < 0 && errno != ECHILD, "Lost track of the accused!");
+      accused_pid = 0;
    }
 }
 
@@ -404,28 +1315,60 @@ inline int final_time() {
    return milliseconds(&total);
 }
 
+// With a cgroup, time and memory include all processes and threads
+// (and memory is the resident one).
+
+int accused_time_ms() {
+   long long usec = -1;
+   if (cgroup_dir[0] != 0) {
+      usec = cgroup_read("cpu.stat", "usage_usec");
+   }
+   return usec >= 0 ? usec / 1000 : final_time();
+}
+
+int accused_mem_kb() {
+   long long bytes = -1;
+   if (cgroup_dir[0] != 0) {
+      bytes = cgroup_read("memory.peak", NULL); // (Linux >= 5.19)
+   }
+   return bytes >= 0 ? bytes / 1024 : accused_mem_peak_kb;
+}
+
 void accused_exited(int stat) {
-   accused_pid[0] = 0;
+   accused_pid = 0;
+   kill_tracees(); // children left behind
    if (!passed_exec) {
       die("Internal Error\n");
    }
+   output_finish();
+   output_check();
+   if (cgroup_oom_killed()) {
+      report_execerror("Memory Limit Exceeded");
+   }
+   if (accused_time_ms() > max_cpu_ms) { // (the timer is periodic)
+      report_execerror("Time Limit Exceeded");
+   }
    int code = WEXITSTATUS(stat);
+   accused_exit_code = code;
    if (code != 0) {
       report_failure("Non-Zero Status\n%d\n", code);
    }
    report_success("Ok\n%.3f sec\n%.3f MB\n", 
-                  final_time() / 1000.0, 
-                  accused_mem_peak_kb / 1024.0);
+                  accused_time_ms() / 1000.0, 
+                  accused_mem_kb() / 1024.0);
 }
 
 void accused_signaled(int stat) {
-   accused_pid[0] = 0;
+   accused_pid = 0;
+   accused_signal = WTERMSIG(stat);
+   kill_tracees();
+   if (cgroup_oom_killed()) {
+      report_execerror("Memory Limit Exceeded");
+   }
    report_failure("Execution Error\nSignalled %d\n", WTERMSIG(stat));
 }
 
-int curr_sys = -1;
-
-const char *get_syscall_filename_arg(uint64_t addr) {
+const char *get_syscall_filename_arg(Tracee *T, uint64_t addr) {
    static char namebuf[4096];
    char *p = namebuf, *end = namebuf;
    do {
@@ -434,7 +1377,7 @@ const char *get_syscall_filename_arg(uint64_t addr) {
          int l = namebuf + sizeof(namebuf) - end;
          if (l > remains) l = remains;
          if (!l) report_execerror("Access to file with name too long");
-         remains = read_user_mem(accused_pid[0], addr, end, l);
+         remains = read_user_mem(T, addr, end, l);
          die_if(remains < 0, "read(mem): %s\n", strerror(errno));
          if (!remains) {
             report_execerror("Access to file with name out of memory");
@@ -446,17 +1389,112 @@ const char *get_syscall_filename_arg(uint64_t addr) {
    return namebuf;
 }
 
-void get_syscall_args(syscall_args *args, int after) {
-   int ret = ptrace(PTRACE_GETREGS, accused_pid[0], NULL, &user);
+#if defined(__x86_64__)
+
+struct user user;
+
+void get_regs(Tracee *T) {
+   int ret = ptrace(PTRACE_GETREGS, T->pid, NULL, &user);
    die_if(ret < 0, "ptrace(PTRACE_GETREGS)\n");
+}
+
+/*
+   The kernel knows if a syscall is a 32-bit one (PTRACE_GET_SYSCALL_INFO,
+   Linux >= 5.3), even with 'int 0x80' in 64-bit code (the code
+   segment is not enough). Without it, we look at the instruction
+   before 'rip' ('int 0x80' or 'sysenter').
+*/
+int get_syscall_abi(Tracee *T) {
+   int abi = ABI_X86_64;
+#ifdef PTRACE_GET_SYSCALL_INFO
+   struct __ptrace_syscall_info info;
+   if (ptrace(PTRACE_GET_SYSCALL_INFO, T->pid, sizeof(info), &info) > 0 &&
+       info.op != PTRACE_SYSCALL_INFO_NONE) {
+      if (info.arch == AUDIT_ARCH_I386) {
+         abi = ABI_I386;
+      } else if (info.arch != AUDIT_ARCH_X86_64) {
+         return ABI_UNKNOWN;
+      }
+   } else
+#endif
+   {
+      unsigned char insn[2];
+      if (user.regs.cs == 0x23) { // 32-bit code
+         abi = ABI_I386;
+      } else if (read_user_mem(T, user.regs.rip - 2, (char *)insn, 2) == 2 &&
+                 ((insn[0] == 0xcd && insn[1] == 0x80) ||
+                  (insn[0] == 0x0f && insn[1] == 0x34))) {
+         abi = ABI_I386;
+      }
+   }
+   if (abi == ABI_X86_64 && (int64_t)user.regs.orig_rax >= 0 &&
+       (user.regs.orig_rax & X32_SYSCALL_BIT)) {
+      abi = ABI_X32;
+   }
+   return abi;
+}
+
+void get_syscall_regs(Tracee *T, syscall_args *args, int after) {
+   get_regs(T);
+   args->abi = get_syscall_abi(T);
    args->sys = user.regs.orig_rax;
+   if (args->abi == ABI_X32) {
+      args->sys &= ~X32_SYSCALL_BIT;
+   }
    args->result = user.regs.rax;
    if (after) return;
-   // OJO: Asumimos (sys_type == 64) en box.c original
-   // TODO: Protección de syscalls de 32-bits en modo 64-bits???
-   args->arg[1] = user.regs.rdi;
-   args->arg[2] = user.regs.rsi;
-   args->arg[3] = user.regs.rdx;
+   if (args->abi == ABI_I386) {
+      args->arg[1] = user.regs.rbx;
+      args->arg[2] = user.regs.rcx;
+      args->arg[3] = user.regs.rdx;
+   } else {
+      args->arg[1] = user.regs.rdi;
+      args->arg[2] = user.regs.rsi;
+      args->arg[3] = user.regs.rdx;
+   }
+}
+
+// The ABI of a new executable (at PTRACE_EVENT_EXEC)
+int get_exec_abi(Tracee *T) {
+   get_regs(T);
+   return user.regs.cs == 0x23 ? ABI_I386 : ABI_X86_64;
+}
+
+#elif defined(__aarch64__)
+
+// (the registers of a 32-bit process are shorter)
+int get_regs(Tracee *T, struct user_regs_struct *regs) {
+   struct iovec iov = { .iov_base = regs, .iov_len = sizeof(*regs) };
+   int ret = ptrace(PTRACE_GETREGSET, T->pid, NT_PRSTATUS, &iov);
+   die_if(ret < 0, "ptrace(PTRACE_GETREGSET)\n");
+   return iov.iov_len == sizeof(*regs) ? ABI_AARCH64 : ABI_UNKNOWN;
+}
+
+void get_syscall_regs(Tracee *T, syscall_args *args, int after) {
+   struct user_regs_struct regs;
+   args->abi = get_regs(T, &regs);
+   args->sys = regs.regs[8];
+   args->result = regs.regs[0];
+   if (after) return;
+   args->arg[1] = regs.regs[0];
+   args->arg[2] = regs.regs[1];
+   args->arg[3] = regs.regs[2];
+}
+
+int get_exec_abi(Tracee *T) {
+   struct user_regs_struct regs;
+   return get_regs(T, &regs);
+}
+
+#endif
+
+void get_syscall_args(Tracee *T, syscall_args *args, int after) {
+   get_syscall_regs(T, args, after);
+   args->name = syscall_name(args->abi, args->sys);
+}
+
+int syscall_is(syscall_args *args, const char *name) {
+   return args->name != NULL && !strcmp(args->name, name);
 }
 
 /*
@@ -464,26 +1502,30 @@ void get_syscall_args(syscall_args *args, int after) {
    http://www.lxhp.in-berlin.de/lhpsysc0.html
 */
 
-char *syscall_to_string(syscall_args *args) {
+char *syscall_to_string(Tracee *T, syscall_args *args) {
    static char repr[4096];
    char *cur = repr;
 
-   const char *name = syscall_name(args->sys);
-   if (name == NULL) name = "?";
-
    intmax_t arg[] = { args->arg[1], args->arg[2], args->arg[3] };
 
-   const char *types = syscall_arg_types(args->sys);
+   const char *types = syscall_arg_types(args->name);
    if (types == NULL) types = "___";
    int i, len = strlen(types);
    
-   cur += sprintf(cur, "%s(", name);
+   if (args->abi != NATIVE_ABI) {
+      cur += sprintf(cur, "%s:", abis[args->abi].name); // e.g. "i386:open(...)"
+   }
+   if (args->name != NULL) {
+      cur += sprintf(cur, "%s(", args->name);
+   } else {
+      cur += sprintf(cur, "syscall_%lu(", args->sys);
+   }
    for (i = 0; i < len; i++) {
       if (types[i] == '*') break;
       if (i > 0) *cur++ = ',';
       switch (types[i]) {
       case 'i': cur += sprintf(cur, "%lu", (uint64_t)arg[i]); break;
-      case 'f': cur += sprintf(cur, "\"%s\"", get_syscall_filename_arg(arg[i])); break;
+      case 'f': cur += sprintf(cur, "\"%s\"", get_syscall_filename_arg(T, arg[i])); break;
       case '.': cur += sprintf(cur, "_"); break;
       default:  cur += sprintf(cur, "%lx", arg[i]);
       };
@@ -492,27 +1534,44 @@ char *syscall_to_string(syscall_args *args) {
    return repr;
 }
 
-void accused_before_syscall() {
+void accused_before_syscall(Tracee *T) {
    syscall_args args;
-   get_syscall_args(&args, 0);
-   curr_sys = args.sys;
-   if (!passed_exec) {
-      if (args.sys == SYS(execve)) {
+   get_syscall_args(T, &args, 0);
+   T->curr_sys = args.sys;
+   if (args.name != NULL) {
+      syscall_counts[args.abi][args.sys]++;
+   }
+   if (!passed_exec && T->pid == accused_pid) {
+      if (syscall_is(&args, "execve")) {
          passed_exec = 1;
          return;
       }
    }
 
    // Maybe sample mem peak
-   if (args.sys == SYS(exit) || args.sys == SYS(exit_group)) {
+   if (syscall_is(&args, "exit") || syscall_is(&args, "exit_group")) {
       accused_sample_mem_peak();
    }
    
-   char *repr = syscall_to_string(&args);
+   char *repr = syscall_to_string(T, &args);
    // fprintf(stderr, "%s\n", repr);
 
+   int wrong_abi = args.abi != expected_abi;
+   int forbidden = wrong_abi || (accused_mode && !syscall_allowed(repr));
+   if (audit != NULL) {
+      int ms = ellapsed_time_ms();
+      audit_add("%d.%03d [%d] %s%s\n", ms / 1000, ms % 1000, 
+                (int)T->pid, repr, (forbidden ? " FORBIDDEN" : ""));
+   }
+
+   if (wrong_abi) {
+      accused_forbidden = repr;
+      report_failure("Execution Error\nForbidden Syscall ABI '%s'\n", 
+                     abis[args.abi].name);
+   }
    if (accused_mode) {
-      if (!syscall_list_find(repr)) {
+      if (forbidden) {
+         accused_forbidden = repr;
          report_failure("Execution Error\nForbidden Syscall '%s'\n", repr);
       }
    } else {
@@ -526,43 +1585,84 @@ void accused_before_syscall() {
    }
 }
 
-void accused_after_syscall() {
+void accused_after_syscall(Tracee *T) {
    syscall_args args;
-   get_syscall_args(&args, 1);
+   get_syscall_args(T, &args, 1);
    if (args.sys == ~(uint64_t)0) {
       // Check return value? Why?
    } else {
-      if (args.sys != curr_sys) {
+      // (after an exec, the number is that of the new ABI)
+      if (args.sys != T->curr_sys && !syscall_is(&args, "execve")) {
          report_execerror("Mismatched syscall before/after");
       }
-      int s = args.sys;
-      if (s == SYS(brk) || s == SYS(mmap) || s == SYS(mremap)) {
+      if (syscall_is(&args, "brk") || syscall_is(&args, "mmap") ||
+          syscall_is(&args, "mmap2") || syscall_is(&args, "mremap")) {
          // Hack: parece que result es -ENOMEM, pero esto es solo empírico...
          if ((int)args.result == -ENOMEM) { 
             report_execerror("Memory Limit Exceeded");
          }
       }
    }
-   curr_sys = -1;
+   T->curr_sys = -1;
 }
 
-void accused_stopped(int stat) {
-   static int stop_count = 0, sys_tick = 0;
+const int ptrace_options = 
+   PTRACE_O_TRACESYSGOOD | PTRACE_O_TRACEEXEC |
+   PTRACE_O_TRACEFORK | PTRACE_O_TRACEVFORK | PTRACE_O_TRACECLONE |
+   PTRACE_O_TRACESECCOMP;
+
+void accused_event(Tracee *T, int event) {
+   switch (event) {
+   case PTRACE_EVENT_FORK:
+   case PTRACE_EVENT_VFORK:
+   case PTRACE_EVENT_CLONE: {
+      unsigned long child;
+      int ret = ptrace(PTRACE_GETEVENTMSG, T->pid, NULL, &child);
+      die_if(ret < 0, "ptrace(PTRACE_GETEVENTMSG)\n");
+      tracee_add(child); // (it might have stopped already)
+      break;
+   }
+   case PTRACE_EVENT_EXEC:
+      if (T->pid == accused_pid) {
+         expected_abi = get_exec_abi(T);
+      }
+      break;
+   case PTRACE_EVENT_SECCOMP:
+      // Syscall entry (the exit stop comes with PTRACE_SYSCALL)
+      T->in_syscall = 1;
+      accused_before_syscall(T);
+      break;
+   }
+}
 
+void accused_stopped(Tracee *T, int stat) {
    int sig = WSTOPSIG(stat);
-
-   if (sig == SIGSTOP) {
-      // first signal
-      int ret = ptrace(PTRACE_SETOPTIONS, accused_pid[0], NULL, 
-                       (void*) PTRACE_O_TRACESYSGOOD);
-      die_if(ret < 0, "ptrace(PTRACE_SETOPTIONS)");
+   int event = stat >> 16;
+   int deliver = 0;
+
+   if (event != 0) {
+      accused_event(T, event);
+   } else if (sig == SIGSTOP) {
+      if (!T->started) {
+         // first signal (children inherit the options)
+         T->started = 1;
+         int ret = ptrace(PTRACE_SETOPTIONS, T->pid, NULL, 
+                          (void*)(long) ptrace_options);
+         die_if(ret < 0, "ptrace(PTRACE_SETOPTIONS)");
+      }
    } else if (sig == (SIGTRAP | 0x80)) {  // Syscall
-      if (++sys_tick & 1) { // Syscall entry
-         accused_before_syscall();
+      T->in_syscall = !T->in_syscall;
+      if (T->in_syscall) { // Syscall entry
+         accused_before_syscall(T);
       } else {
-         accused_after_syscall();
+         accused_after_syscall(T);
       }
    } else {
+      switch (sig) {
+      case SIGABRT: case SIGINT: case SIGILL: case SIGSEGV:
+      case SIGXCPU: case SIGXFSZ: case SIGTRAP:
+         accused_signal = sig; // (reported below)
+      }
       switch (sig) {
       case SIGABRT: report_execerror("Aborted");
       case SIGINT:  report_execerror("Interrupted");
@@ -570,24 +1670,98 @@ void accused_stopped(int stat) {
       case SIGSEGV: report_execerror("Segmentation Fault");
       case SIGXCPU: report_execerror("Time Limit Exceeded");
       case SIGXFSZ: report_execerror("File Size Exceeded");
-      case SIGTRAP: 
-         if (++stop_count > 1) {
-            report_execerror("Breakpoint");
-         }
+      case SIGTRAP: report_execerror("Breakpoint");
+      default:
+         deliver = sig; // e.g. SIGCHLD
       }
    }
-   ptrace(PTRACE_SYSCALL, accused_pid[0], 0, 0);
+   // In seccomp mode, only stop at the exit of traced syscalls
+   int request = PTRACE_SYSCALL;
+   if (seccomp_mode && !T->in_syscall) {
+      request = PTRACE_CONT;
+   }
+   ptrace(request, T->pid, 0, deliver);
 }
 
-inline void get_start_time() {
-   gettimeofday(&start_time, NULL);
+/** Timer **/
+
+/*
+   The timer sends SIGALRM periodically to the guardian's thread (not
+   to the process, since the Go runtime has other threads), so that
+   'wait4' gets EINTR and the limits can be checked.
+*/
+
+#define TIMER_MS 10
+
+volatile sig_atomic_t timer_ticked = 0;
+
+void timer_handler(int sig) {
+   timer_ticked = 1;
 }
 
-int ellapsed_time_ms() {
-   struct timeval now, wall;
-   gettimeofday(&now, NULL);
-   timersub(&now, &start_time, &wall);
-   return wall.tv_sec * 1000 + wall.tv_usec/1000;
+void start_timer() {
+   if (max_wall_ms <= 0) {
+      max_wall_ms = 2 * max_cpu_ms + 1000;
+   }
+   get_start_time();
+
+   struct sigaction sa;
+   memset(&sa, 0, sizeof(sa));
+   sa.sa_handler = timer_handler; // no SA_RESTART: wait4 gets EINTR
+   die_if(sigaction(SIGALRM, &sa, NULL) < 0, "sigaction(SIGALRM)\n");
+
+   timer_t timer;
+   struct sigevent sev;
+   memset(&sev, 0, sizeof(sev));
+   sev.sigev_notify = SIGEV_THREAD_ID;
+   sev.sigev_signo = SIGALRM;
+   sev._sigev_un._tid = syscall(SYS_gettid);
+   die_if(timer_create(CLOCK_MONOTONIC, &sev, &timer) < 0, 
+          "timer_create: %s\n", strerror(errno));
+   struct itimerspec its = {
+      .it_interval = { 0, TIMER_MS * 1000000 },
+      .it_value    = { 0, TIMER_MS * 1000000 },
+   };
+   die_if(timer_settime(timer, 0, &its, NULL) < 0, 
+          "timer_settime: %s\n", strerror(errno));
+}
+
+// CPU time (so far) of the accused (with a cgroup, of all processes)
+int accused_cpu_ms() {
+   if (cgroup_dir[0] != 0) {
+      long long usec = cgroup_read("cpu.stat", "usage_usec");
+      if (usec >= 0) {
+         return usec / 1000;
+      }
+   }
+   char buf[PROC_BUF_SIZE];
+   read_proc_stat(accused_pid, buf);
+   char *x = strrchr(buf, ')'); // (the name might have spaces)
+   unsigned long utime, stime;
+   if (x == NULL || 2 != sscanf(x + 2, "%*c %*d %*d %*d %*d %*d %*u %*u %*u %*u %*u %lu %lu", 
+                                &utime, &stime)) {
+      die("Cannot parse /proc/%d/stat\n", (int)accused_pid);
+   }
+   return (utime + stime) * 1000 / sysconf(_SC_CLK_TCK);
+}
+
+void accused_check_limits() {
+   static int last_cpu = -1, last_busy = 0;
+   output_check();
+   int wall = ellapsed_time_ms();
+   if (wall > max_wall_ms) {
+      report_execerror("Wall Time Limit Exceeded");
+   }
+   int cpu = accused_cpu_ms();
+   if (cpu > max_cpu_ms) {
+      report_execerror("Time Limit Exceeded");
+   }
+   if (cpu != last_cpu) {
+      last_cpu = cpu;
+      last_busy = wall;
+   } else if (max_idle_ms > 0 && wall - last_busy > max_idle_ms) {
+      report_execerror("Idleness Limit Exceeded");
+   }
 }
 
 void check_exe(char *dir) {
@@ -601,40 +1775,288 @@ void check_exe(char *dir) {
 
 void guardian() {
    int stat;
+   struct rusage ru;
 
-   get_start_time();
+   start_timer();
    
    while (1) {
-      int cont = wait_for_accused(&stat);
-      if (cont) {
+      pid_t p = wait4(-1, &stat, __WALL, &ru);
+      if (p < 0 && errno == EINTR) {
+         if (timer_ticked) {
+            timer_ticked = 0;
+            accused_check_limits();
+         }
          continue;
-      } else if (WIFEXITED(stat)) {
-         accused_exited(stat);
-      } else if (WIFSIGNALED(stat)) {
-         accused_signaled(stat);
+      }
+      die_if(p < 0, "wait4 error %d\n", errno);
+      if (WIFEXITED(stat) || WIFSIGNALED(stat)) {
+         tracee_remove(p);
+         if (p == accused_pid) {
+            usage = ru;
+            if (WIFEXITED(stat)) {
+               accused_exited(stat);
+            } else {
+               accused_signaled(stat);
+            }
+         }
       } else if (WIFSTOPPED(stat)) {
-         accused_stopped(stat);
+         accused_stopped(tracee_add(p), stat);
       } else {
          die("wait4: unknown status '%d'", stat);
       }
    }
 }
 
+/** Compiler **/
+
+pid_t compiler_pid = 0;
+
+void kill_compiler() {
+   if (compiler_pid > 0) {
+      kill(-compiler_pid, SIGKILL); // the whole process group
+      int p, stat;
+      do {
+         p = wait4(compiler_pid, &stat, 0, &usage);
+      } while (p < 0 && errno == EINTR);
+      compiler_pid = 0;
+   }
+}
+
+void isolate_network() {
+   // A new user namespace allows unprivileged users to create a
+   // network namespace (with only a loopback interface down).
+   if (unshare(CLONE_NEWUSER | CLONE_NEWNET) < 0) {
+      die_if(unshare(CLONE_NEWNET) < 0,
+             "unshare(CLONE_NEWNET): %s\n", strerror(errno));
+   }
+}
+
+void the_compiler(char *dir, char **argv) {
+   die_if(setpgid(0, 0) < 0, "setpgid: %s\n", strerror(errno));
+   die_if(chdir(dir) < 0, "chdir(\"%s\"): %s\n", dir, strerror(errno));
+   isolate_network();
+   setlimit(RLIMIT_CPU,   max_cpu_seconds());
+   setlimit(RLIMIT_AS,    max_memory);
+   setlimit(RLIMIT_FSIZE, max_file_size);
+   int fd = open(compile_output, O_WRONLY | O_CREAT | O_TRUNC, 0600);
+   die_if(fd < 0, "open(\"%s\"): %s\n", compile_output, strerror(errno));
+   close(0);
+   die_if(0 != open("/dev/null", O_RDONLY), "Redirect stdin from '/dev/null'\n");
+   die_if(dup2(fd, 1) < 0 || dup2(fd, 2) < 0, "Redirect output to '%s'\n", compile_output);
+   close(fd);
+   execvp(argv[0], argv);
+   die("execvp(\"%s\"): %s\n", argv[0], strerror(errno));
+}
+
+void compiler_guardian() {
+   start_timer();
+
+   int stat;
+   while (1) {
+      pid_t p = wait4(compiler_pid, &stat, 0, &usage);
+      if (p < 0 && errno == EINTR) {
+         if (ellapsed_time_ms() > max_wall_ms) {
+            report_execerror("Time Limit Exceeded");
+         }
+         continue;
+      }
+      die_if(p < 0, "wait4 error %d\n", errno);
+      if (WIFEXITED(stat)) {
+         compiler_pid = 0;
+         kill(-p, SIGKILL); // children left behind
+         int code = WEXITSTATUS(stat);
+         if (code != 0) {
+            report_failure("Non-Zero Status\n%d\n", code);
+         }
+         report_success("Ok\n%.3f sec\n%.3f MB\n",
+                        final_time() / 1000.0,
+                        usage.ru_maxrss / 1024.0);
+      } else if (WIFSIGNALED(stat)) {
+         compiler_pid = 0;
+         kill(-p, SIGKILL);
+         switch (WTERMSIG(stat)) {
+         case SIGXCPU: report_execerror("Time Limit Exceeded");
+         case SIGXFSZ: report_execerror("File Size Exceeded");
+         default:
+            report_failure("Execution Error\nSignalled %d\n", WTERMSIG(stat));
+         }
+      }
+   }
+}
+
+void grzcompile(char *dir, char **argv) {
+   compiler_pid = fork();
+   die_if(compiler_pid < 0, "Couldn't fork\n");
+   if (compiler_pid == 0) { // Child
+      the_compiler(dir, argv);
+   } else {
+      setpgid(compiler_pid, compiler_pid); // avoid races with the child
+      compiler_guardian();
+   }
+}
+
+/** Report **/
+
+void json_string(FILE *f, const char *s) {
+   fputc('"', f);
+   for (; *s; s++) {
+      switch (*s) {
+      case '"':  fputs("\\\"", f); break;
+      case '\\': fputs("\\\\", f); break;
+      case '\n': fputs("\\n", f); break;
+      default:
+         if ((unsigned char)*s < 0x20) {
+            fprintf(f, "\\u%04x", *s);
+         } else {
+            fputc(*s, f);
+         }
+      }
+   }
+   fputc('"', f);
+}
+
+/*
+   Writes the report in JSON from the message that would be written
+   in text mode (status in the first line, reason in the second).
+*/
+void write_json_report(int code, char *msg) {
+   char *status = "Internal Error", *reason = "", *message = msg;
+   if (code == 0 || code == 1) {
+      status = msg;
+      message = "";
+      char *nl = strchr(msg, '\n');
+      if (nl != NULL) {
+         *nl = 0;
+         reason = nl + 1;
+         nl = strchr(reason, '\n');
+         if (nl != NULL) *nl = 0;
+      }
+      if (code == 0 || !strcmp(status, "Non-Zero Status")) {
+         reason = ""; // (time and memory, or the exit code)
+      }
+   } else {
+      int len = strlen(msg);
+      if (len > 0 && msg[len-1] == '\n') msg[len-1] = 0;
+   }
+
+   int cpu_ms, memory_kb, peak_rss_kb;
+   if (compile_output != NULL) {
+      cpu_ms = final_time();
+      memory_kb = peak_rss_kb = usage.ru_maxrss;
+   } else {
+      cpu_ms = accused_time_ms();
+      memory_kb = accused_mem_kb();
+      long long peak = -1;
+      if (cgroup_dir[0] != 0) {
+         peak = cgroup_read("memory.peak", NULL);
+      }
+      peak_rss_kb = peak >= 0 ? peak / 1024 : usage.ru_maxrss;
+   }
+   int wall_ms = start_time.tv_sec != 0 ? ellapsed_time_ms() : 0;
+
+   fprintf(stderr, "{\"status\": ");
+   json_string(stderr, status);
+   fprintf(stderr, ", \"reason\": ");
+   json_string(stderr, reason);
+   fprintf(stderr, ", \"exit_code\": %d, \"signal\": %d", 
+           accused_exit_code, accused_signal);
+   fprintf(stderr, ", \"cpu_ms\": %d, \"wall_ms\": %d", cpu_ms, wall_ms);
+   fprintf(stderr, ", \"memory_kb\": %d, \"peak_rss_kb\": %d", 
+           memory_kb, peak_rss_kb);
+   fprintf(stderr, ", \"forbidden\": ");
+   json_string(stderr, accused_forbidden != NULL ? accused_forbidden : "");
+   fprintf(stderr, ", \"syscalls\": {");
+   int abi, i, first = 1;
+   for (abi = 0; abi < NUM_ABIS; abi++) {
+      for (i = 0; i < abis[abi].num; i++) {
+         if (syscall_counts[abi][i] > 0 && syscall_name(abi, i) != NULL) {
+            fprintf(stderr, "%s\"%s%s%s\": %d", (first ? "" : ", "), 
+                    (abi != NATIVE_ABI ? abis[abi].name : ""),
+                    (abi != NATIVE_ABI ? ":" : ""),
+                    syscall_name(abi, i), syscall_counts[abi][i]);
+            first = 0;
+         }
+      }
+   }
+   fprintf(stderr, "}, \"message\": ");
+   json_string(stderr, message);
+   fprintf(stderr, "}\n");
+}
+
+int clone_accused_fn(void *dir) {
+   the_accused((char *)dir);
+   return 2; // not reached
+}
+
+pid_t clone_accused(char *dir) {
+   // The accused is the 'init' of its PID namespace (but, being
+   // traced, its signals still reach the guardian).
+   const int stack_size = 1024 * 1024;
+   char *stack = malloc(stack_size);
+   die_if(stack == NULL, "Couldn't allocate stack for clone\n");
+   if (sandbox_uid >= 0) {
+      // The user namespace belongs to the effective user that
+      // creates it, so we are the sandbox user (only) for the clone
+      die_if(setgroups(0, NULL) < 0, "setgroups: %s\n", strerror(errno));
+      die_if(setegid(sandbox_uid) < 0, "setegid(%d): %s\n", sandbox_uid, strerror(errno));
+      die_if(seteuid(sandbox_uid) < 0, "seteuid(%d): %s\n", sandbox_uid, strerror(errno));
+   }
+   outer_uid = geteuid();
+   outer_gid = getegid();
+   pid_t pid = clone(clone_accused_fn, stack + stack_size, 
+                     namespace_flags | SIGCHLD, dir);
+   int error = errno;
+   if (sandbox_uid >= 0) {
+      die_if(seteuid(getuid()) < 0, "seteuid: %s\n", strerror(errno));
+      die_if(setegid(getgid()) < 0, "setegid: %s\n", strerror(errno));
+   }
+   die_if(pid < 0, "clone (namespaces): %s\n", strerror(error));
+   return pid;
+}
+
 void grzjail(char *dir) {
    check_exe(dir);
 
    if (accused_mode) {
       syscall_list_read();
+      if (policy_file != NULL) {
+         policy_read();
+      }
    } else {
+      seccomp_mode = 0; // the model has to be traced completely
       perm_fd = open(perm_file, O_WRONLY | O_CREAT | O_TRUNC, 0600);
       die_if(perm_fd < 0, "Couldn't open '%s'\n", perm_file);
    }
+   if (audit_file != NULL) {
+      audit = fopen(audit_file, "we"); // (written at exit)
+      die_if(audit == NULL, "Couldn't open '%s': %s\n", audit_file, strerror(errno));
+   }
 
-   accused_pid[0] = fork();
-   die_if(accused_pid[0] < 0, "Couldn't fork\n");
-   if (accused_pid[0] == 0) { // Child
-      the_accused(dir);
+   if (cgroup_parent != NULL) {
+      cgroup_create();
+      if (accused_mode) { // (the Go runtime reads its CPU quota)
+         char pattern[PATH_MAX + 32];
+         snprintf(pattern, sizeof(pattern), "openat(*,\"%s/cpu.max\")", cgroup_dir);
+         policy_add(1, pattern);
+      }
+   }
+   if (seccomp_mode) {
+      seccomp_build();
+   }
+   output_create();
+   if (namespace_mode) {
+      accused_pid = clone_accused(dir);
    } else {
-      guardian();
+      accused_pid = fork();
+      die_if(accused_pid < 0, "Couldn't fork\n");
+      if (accused_pid == 0) { // Child
+         the_accused(dir);
+      }
+   }
+   if (cgroup_parent != NULL) {
+      // (the accused waits in its SIGSTOP, before the exec)
+      cgroup_add(accused_pid);
    }
+   output_start();
+   guardian();
 }
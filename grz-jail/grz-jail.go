@@ -1,57 +1,62 @@
+//go:build cgo
+
 package main
 
 /* 
 
+#cgo LDFLAGS: -lrt
 #include "grz-jail.h"
 
 */
 import "C"
 
 import (
-	"flag"
 	"fmt"
 	"os"
 )
 
-var (
-	MaxCpuSeconds int
-	MaxMemory     int
-	MaxFileSize   int
-	AccusedMode   bool
-)
-
-const s_usage = `usage: grz-jail [options...] <directory>
-
-Options:
-   -m <mem>   Max megabytes of memory
-   -t <mem>   Max seconds
-   -f <mem>   Max megabytes for files
-   -a         Accused mode
-
-`
-
-func usage() {
-	fmt.Fprintf(os.Stderr, s_usage)
-}
-
 func main() {
-	flag.Usage = usage
-	flag.IntVar(&MaxCpuSeconds, "t", 2, "<dummy>")
-	flag.IntVar(&MaxMemory, "m", 64*1024*1024, "<dummy>")
-	flag.IntVar(&MaxFileSize, "f", 1024, "<dummy>")
-	flag.BoolVar(&AccusedMode, "a", false, "<dummy>")
-	flag.Parse()
-	args := flag.Args()
-	if len(args) != 1 {
-		fmt.Fprintf(os.Stderr, "Wrong number of arguments\n")
-		os.Exit(3)
-	}
-	C.max_cpu_seconds = C.int(MaxCpuSeconds)
+	args := parseFlags()
+	C.max_cpu_ms = C.int(MaxCpuSeconds * 1000)
+	C.max_wall_ms = C.int(MaxWallSeconds * 1000)
+	C.max_idle_ms = C.int(MaxIdleSeconds * 1000)
 	C.max_memory = C.int(MaxMemory)
 	C.max_file_size = C.int(MaxFileSize)
+	C.max_output = C.int(MaxOutput)
+	if StderrFile != "" {
+		C.stderr_file = C.CString(StderrFile)
+	}
 	if AccusedMode {
 		C.accused_mode = C.int(1)
 	}
+	if SeccompMode {
+		C.seccomp_mode = C.int(1)
+	}
+	if Namespaces {
+		C.namespace_mode = C.int(1)
+	}
+	if CgroupParent != "" {
+		C.cgroup_parent = C.CString(CgroupParent)
+	}
+	C.max_pids = C.int(MaxPids)
+	C.sandbox_uid = C.int(SandboxUid)
+	if JSONReport {
+		C.json_report = C.int(1)
+	}
+	if PolicyFile != "" {
+		C.policy_file = C.CString(PolicyFile)
+	}
+	if AuditFile != "" {
+		C.audit_file = C.CString(AuditFile)
+	}
+	if CompileOutput != "" {
+		C.compile_output = C.CString(CompileOutput)
+		argv := make([]*C.char, len(args))
+		for i, arg := range args[1:] {
+			argv[i] = C.CString(arg)
+		}
+		C.grzcompile(C.CString(args[0]), &argv[0])
+	}
 	C.grzjail(C.CString(args[0]))
 	fmt.Fprintf(os.Stderr, "You've just seen an error in The Matrix") // we shouldn't be here
 	os.Exit(3)
@@ -1,7 +1,21 @@
 
-extern int max_cpu_seconds;
+extern int max_cpu_ms;
+extern int max_wall_ms;
+extern int max_idle_ms;
 extern int max_memory;
 extern int max_file_size;
+extern int max_output;
+extern char *stderr_file;
 extern int accused_mode;
+extern int seccomp_mode;
+extern int namespace_mode;
+extern char *cgroup_parent;
+extern int json_report;
+extern char *policy_file;
+extern char *audit_file;
+extern int max_pids;
+extern int sandbox_uid;
+extern char *compile_output;
 
 void grzjail(char *dir);
+void grzcompile(char *dir, char **argv);
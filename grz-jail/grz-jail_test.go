@@ -0,0 +1,401 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/pauek/garzon/jail"
+)
+
+// The tests build grz-jail and run the programs of 'test/cc' and
+// 'test/go' with it (as the script 'test/cc/test' does).
+
+var grzjail string // path of the grz-jail that is tested
+
+func TestMain(m *testing.M) {
+	dir, err := ioutil.TempDir("", "grz-jail-test")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot create directory: %s\n", err)
+		os.Exit(1)
+	}
+	out, err := exec.Command("go", "build", "-o", filepath.Join(dir, "grz-jail"), ".").CombinedOutput()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot build grz-jail (tests skipped): %s\n%s", err, out)
+	} else {
+		grzjail = filepath.Join(dir, "grz-jail")
+	}
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// compile compiles a test program to 'exe' in dir.
+func compile(t *testing.T, dir, file string) {
+	if grzjail == "" {
+		t.Skip("No grz-jail")
+	}
+	exe := filepath.Join(dir, "exe")
+	var cmd *exec.Cmd
+	switch filepath.Ext(file) {
+	case ".c":
+		cmd = exec.Command("gcc", "-static", "-o", exe, filepath.Join("test/cc", file))
+	case ".cc":
+		cmd = exec.Command("g++", "-static", "-o", exe, filepath.Join("test/cc", file))
+	case ".go":
+		path, err := filepath.Abs(filepath.Join("test/go", file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		cmd = exec.Command("go", "build", "-o", exe, path)
+	}
+	if _, err := exec.LookPath(cmd.Args[0]); err != nil {
+		t.Skipf("No compiler '%s'", cmd.Args[0])
+	}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("Cannot compile '%s': %s\n%s", file, err, out)
+	}
+}
+
+// run runs grz-jail with the options and returns its report.
+func run(t *testing.T, dir string, options ...string) jail.Result {
+	return runInput(t, dir, "", options...)
+}
+
+// runInput is run with an input for the program.
+func runInput(t *testing.T, dir, input string, options ...string) jail.Result {
+	var stderr bytes.Buffer
+	args := append(append([]string{"-json"}, options...), dir)
+	cmd := exec.Command(grzjail, args...)
+	cmd.Dir = dir
+	cmd.Stdin = strings.NewReader(input)
+	cmd.Stderr = &stderr
+	cmd.Run()
+	var R jail.Result
+	if err := json.Unmarshal(stderr.Bytes(), &R); err != nil {
+		t.Fatalf("Cannot decode the report: %s\n%s", err, stderr.String())
+	}
+	if R.Status == "Internal Error" && strings.Contains(R.Message, "ptrace") {
+		t.Skipf("Cannot trace: %s", R.Message)
+	}
+	return R
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "grz-jail-test")
+	if err != nil {
+		t.Fatalf("Cannot create directory: %s", err)
+	}
+	return dir
+}
+
+func check(t *testing.T, what string, R jail.Result, status, reason string) {
+	if R.Status != status || R.Reason != reason {
+		t.Errorf("%s: expected '%s' (%s), got '%s' (%s) %s",
+			what, status, reason, R.Status, R.Reason, R.Message)
+	}
+}
+
+var goMemory = []string{"-m", "2000000000"} // (the Go runtime reserves a lot)
+
+func TestModel(t *testing.T) {
+	tests := []struct {
+		file, status, reason string
+	}{
+		{"inf_loop.c", "Execution Error", "Time Limit Exceeded"},
+		{"mem_sink.cc", "Execution Error", "Memory Limit Exceeded"},
+		{"segfault.c", "Execution Error", "Segmentation Fault"},
+		{"sigint.c", "Execution Error", "Interrupted"},
+		{"abort.c", "Execution Error", "Aborted"},
+		{"good_vector_1e4_rep.cc", "Ok", ""},
+		{"good_vector_1e6.cc", "Ok", ""},
+		{"good_vector_1e7.cc", "Ok", ""},
+		{"bad_vector_1e8.cc", "Execution Error", "Memory Limit Exceeded"},
+		{"open_file.cc", "Ok", ""},
+		{"good1.go", "Ok", ""},
+		{"good2.go", "Ok", ""},
+	}
+	for _, test := range tests {
+		dir := tempDir(t)
+		defer os.RemoveAll(dir)
+		compile(t, dir, test.file)
+		options := []string{"-t", "1", "-w", "30"} // (the machine might be busy)
+		if filepath.Ext(test.file) == ".go" {
+			options = append(options, goMemory...)
+		}
+		R := run(t, dir, options...)
+		check(t, test.file, R, test.status, test.reason)
+	}
+}
+
+func TestTiming(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	compile(t, dir, "good_vector_1e7_clear.cc") // (sleeps 1 second)
+	R := run(t, dir, "-t", "1")
+	check(t, "good_vector_1e7_clear.cc", R, "Ok", "")
+	if R.CpuMs > 1000 || R.WallMs < 1000 || R.WallMs > 3000 {
+		t.Errorf("Wrong times: %d ms of CPU, %d ms of wall clock", R.CpuMs, R.WallMs)
+	}
+	if R.MemoryKb < 40000 { // (10^7 ints)
+		t.Errorf("Wrong memory: %d KB", R.MemoryKb)
+	}
+	if idle := run(t, dir, "-t", "1", "-i", "0.5"); idle.Reason != "Idleness Limit Exceeded" {
+		t.Errorf("Sleeping should exceed the idleness limit (is '%s')", idle.Reason)
+	}
+}
+
+func TestWallTime(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	compile(t, dir, "inf_loop.c") // (no syscalls)
+	R := run(t, dir, "-t", "10", "-w", "0.5")
+	check(t, "inf_loop.c", R, "Execution Error", "Wall Time Limit Exceeded")
+	if R.CpuMs >= 10000 || R.WallMs < 500 || R.WallMs > 2000 {
+		t.Errorf("The wall time limit should stop it at 0.5 s (%d ms of CPU, %d ms of wall clock)", R.CpuMs, R.WallMs)
+	}
+}
+
+func TestAccused(t *testing.T) {
+	tests := []struct {
+		model, accused, status, reason string
+	}{
+		{"good_vector_1e6.cc", "good_vector_1e6.cc", "Ok", ""},
+		{"good_vector_1e6.cc", "good_vector_1e4_rep.cc", "Ok", ""},
+		{"good_vector_1e6.cc", "open_file.cc", "Execution Error", ""},
+		{"open_file.cc", "open_file.cc", "Ok", ""},
+		{"good1.go", "good1.go", "Ok", ""},
+		{"good1.go", "good2.go", "Execution Error", ""},
+	}
+	for _, test := range tests {
+		dir := tempDir(t)
+		defer os.RemoveAll(dir)
+		var options []string
+		if filepath.Ext(test.model) == ".go" {
+			options = goMemory
+		}
+		compile(t, dir, test.model)
+		check(t, test.model, run(t, dir, options...), "Ok", "")
+		compile(t, dir, test.accused)
+		what := test.accused + " after " + test.model
+		R := run(t, dir, append(options, "-a")...)
+		if test.status == "Ok" {
+			check(t, what, R, "Ok", "")
+		} else if R.Status != test.status || !strings.HasPrefix(R.Reason, "Forbidden Syscall '") {
+			t.Errorf("%s: expected a forbidden syscall, got '%s' (%s)", what, R.Status, R.Reason)
+		}
+	}
+}
+
+func TestSandboxUid(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("Only root can change the user")
+	}
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	if err := os.Chmod(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	compile(t, dir, "getuid.c")
+	R := run(t, dir, "-u", "20001")
+	if R.Status == "Internal Error" && strings.Contains(R.Message, "prlimit") {
+		t.Skipf("Cannot set limits: %s", R.Message) // (without CAP_SYS_RESOURCE)
+	}
+	if R.Status != "Non-Zero Status" || R.ExitCode != 20001%256 {
+		t.Errorf("The program should run as uid 20001 (is '%s', %d)", R.Status, R.ExitCode)
+	}
+}
+
+func TestAudit(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	compile(t, dir, "many_writes.c")
+	audit := filepath.Join(dir, "audit")
+	check(t, "many_writes.c", run(t, dir, "-A", audit), "Ok", "")
+	data, err := ioutil.ReadFile(audit)
+	if err != nil {
+		t.Fatalf("Cannot read the audit log: %s", err)
+	}
+	lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	if len(lines) != 100 { // (AUDIT_LINES)
+		t.Errorf("The audit log should have the last 100 syscalls (has %d lines)", len(lines))
+	}
+	if last := lines[len(lines)-1]; !strings.HasSuffix(last, "exit_group(0)") {
+		t.Errorf("The audit log should end with the last syscall (is '%s')", last)
+	}
+}
+
+func TestSeccomp(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	compile(t, dir, "many_writes.c")
+	check(t, "model", run(t, dir), "Ok", "")
+	check(t, "seccomp", run(t, dir, "-a", "-s"), "Ok", "")
+
+	// A model with lots of syscalls doesn't fit in a filter (the
+	// accused is then traced completely)
+	list := filepath.Join(dir, ".syscalls")
+	f, err := os.OpenFile(list, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("Cannot open '%s': %s", list, err)
+	}
+	for fd := 100; fd < 5100; fd++ {
+		fmt.Fprintf(f, "read(%d,_,_)\n", fd)
+	}
+	f.Close()
+	check(t, "seccomp (long list)", run(t, dir, "-a", "-s"), "Ok", "")
+}
+
+func TestPolicyPaths(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	compile(t, dir, "open_path.c")
+	check(t, "open_path.c", runInput(t, dir, "data.txt\n"), "Ok", "")
+	policy := filepath.Join(dir, "policy")
+	tests := []struct {
+		rule, path string
+		allowed    bool
+	}{
+		{`allow openat(*,"*.txt")`, "other.txt", true},
+		{`allow openat(*,"*.txt")`, "/etc/a.txt", false}, // ('*' doesn't match '/')
+		{`allow openat(*,"/*/*")`, "/etc/passwd", true},
+		{`allow openat(*,"*/*")`, "sub/../../a", false},
+		{`allow openat(*,"/proc/self/*/*/*")`, "/proc/self/root/etc/passwd", false},
+		{`allow openat(*,"/proc/self/*/*")`, "/proc/self/cwd/exe", false},
+		{`allow openat(*,"/proc/self/*")`, "/proc/self/maps", true},
+	}
+	for _, test := range tests {
+		if err := ioutil.WriteFile(policy, []byte(test.rule+"\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		R := runInput(t, dir, test.path+"\n", "-a", "-P", policy)
+		if allowed := R.Status == "Ok"; allowed != test.allowed {
+			t.Errorf("'%s' with '%s': allowed should be %v (is '%s' %s)",
+				test.path, test.rule, test.allowed, R.Status, R.Reason)
+		}
+	}
+}
+
+// cgroupParent gives a cgroup (v2) in which grz-jail -g can create its
+// cgroups ($GRZ_JAIL_CGROUP, or the root), or skips the test.
+func cgroupParent(t *testing.T) string {
+	parent := os.Getenv("GRZ_JAIL_CGROUP")
+	if parent == "" {
+		parent = "/sys/fs/cgroup"
+	}
+	data, err := ioutil.ReadFile(filepath.Join(parent, "cgroup.subtree_control"))
+	if err != nil {
+		t.Skipf("No cgroup v2 at '%s'", parent)
+	}
+	controllers := " " + strings.Join(strings.Fields(string(data)), " ") + " "
+	for _, c := range []string{"memory", "pids", "cpu"} {
+		if !strings.Contains(controllers, " "+c+" ") {
+			t.Skipf("No '%s' controller in '%s'", c, parent)
+		}
+	}
+	if syscall.Access(parent, 2 /* W_OK */) != nil {
+		t.Skipf("Cannot create cgroups in '%s'", parent)
+	}
+	return parent
+}
+
+func TestCgroup(t *testing.T) {
+	parent := cgroupParent(t)
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	// The peak is read from 'memory.peak' (the vector has 4 MB)
+	compile(t, dir, "good_vector_1e6.cc")
+	R := run(t, dir, "-g", parent)
+	check(t, "good_vector_1e6.cc", R, "Ok", "")
+	if R.MemoryKb < 3900 || R.MemoryKb > 64*1024 {
+		t.Errorf("The memory peak should be about 4 MB (is %d KB)", R.MemoryKb)
+	}
+
+	// 'memory.max' (an OOM kill is "Memory Limit Exceeded")
+	compile(t, dir, "mem_sink.cc")
+	R = run(t, dir, "-g", parent, "-m", fmt.Sprint(32*1024*1024))
+	check(t, "mem_sink.cc", R, "Execution Error", "Memory Limit Exceeded")
+
+	// 'pids.max' (the fork fails)
+	compile(t, dir, "forks.c")
+	R = runInput(t, dir, "10 data.txt\n", "-g", parent, "-p", "4")
+	if R.Status != "Non-Zero Status" || R.ExitCode != 2 {
+		t.Errorf("forks.c with -p 4: fork should fail (is '%s' %s, %d)", R.Status, R.Reason, R.ExitCode)
+	}
+}
+
+func TestNamespaces(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	compile(t, dir, "escape.c")
+	if R := run(t, dir); R.ExitCode&1 == 0 || R.ExitCode&4 == 0 {
+		t.Fatalf("Without -n, escape.c should see the host (exit code %d)", R.ExitCode)
+	}
+	R := run(t, dir, "-n")
+	if R.Status == "Internal Error" {
+		t.Skipf("Cannot create namespaces: %s", R.Message)
+	}
+	for bit, what := range map[int]string{1: "open a host file", 2: "reach the network", 4: "be other than pid 1"} {
+		if R.ExitCode&bit != 0 {
+			t.Errorf("With -n, escape.c shouldn't %s (exit code %d)", what, R.ExitCode)
+		}
+	}
+	check(t, "escape.c with -n", R, "Ok", "")
+
+	// ... and the accused cannot try it if the model doesn't
+	compile(t, dir, "good_vector_1e6.cc")
+	check(t, "model with -n", run(t, dir, "-n"), "Ok", "")
+	compile(t, dir, "escape.c")
+	R = run(t, dir, "-n", "-a")
+	if R.Status != "Execution Error" || !strings.HasPrefix(R.Reason, "Forbidden Syscall '") {
+		t.Errorf("escape.c as the accused should make a forbidden syscall (is '%s': %s)", R.Status, R.Reason)
+	}
+}
+
+func TestChildren(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	compile(t, dir, "forks.c")
+	check(t, "model", runInput(t, dir, "1 data.txt\n"), "Ok", "")
+	check(t, "same children", runInput(t, dir, "3 data.txt\n", "-a"), "Ok", "")
+
+	// The open is made by a child
+	R := runInput(t, dir, "1 /etc/passwd\n", "-a")
+	if R.Status != "Execution Error" || !strings.HasSuffix(R.Forbidden, `"/etc/passwd")`) {
+		t.Errorf("A child should make a forbidden syscall (is '%s': %s)", R.Status, R.Reason)
+	}
+
+	// More than MAX_TRACEES (256) at the same time
+	R = runInput(t, dir, "300 data.txt\n", "-a")
+	check(t, "300 children", R, "Execution Error", "Too Many Processes")
+}
+
+func TestAbi(t *testing.T) {
+	if runtime.GOARCH != "amd64" {
+		t.Skip("The ABIs of the test are of x86_64")
+	}
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	compile(t, dir, "abi.c")
+	check(t, "model", runInput(t, dir, "native\n"), "Ok", "")
+	for _, options := range [][]string{{"-a"}, {"-a", "-s"}} {
+		check(t, "native", runInput(t, dir, "native\n", options...), "Ok", "")
+		for _, abi := range []string{"i386", "x32"} {
+			R := runInput(t, dir, abi+"\n", options...)
+			what := fmt.Sprintf("%s syscall (%s)", abi, strings.Join(options, " "))
+			check(t, what, R, "Execution Error", "Forbidden Syscall ABI '"+abi+"'")
+			if R.Forbidden != abi+":getpid()" {
+				t.Errorf("%s: the forbidden syscall should be '%s:getpid()' (is '%s')", what, abi, R.Forbidden)
+			}
+		}
+	}
+}
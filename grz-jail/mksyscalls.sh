@@ -0,0 +1,38 @@
+#!/bin/sh
+#
+# Generates syscalls.h (the names of syscalls for each ABI) from the
+# kernel headers, which are in /usr/include/x86_64-linux-gnu/asm (for
+# x86-64, x32 and i386) and /usr/include/asm-generic (for aarch64).
+#
+#    ./mksyscalls.sh > syscalls.h
+#
+
+# table <name> <cpp flags> <header> [<offset>]
+table() {
+   name=$1 flags=$2 header=$3 offset=${4:-0}
+   macros=$(echo "#include <$header>" | cpp -dM $flags - |
+            awk '/^#define __NR_[a-z0-9_]+ / { print substr($2, 6) }' |
+            grep -v '^syscalls$\|^arch_specific_syscall$' | sort -u) # (not syscalls)
+   echo "const char *${name}_syscalls[] = {"
+   {
+      echo "#include <$header>"
+      for m in $macros; do echo "NR $m __NR_$m"; done
+   } | cpp -P $flags - | grep '^NR ' | while read _ m expr; do
+      echo "$(( $expr - $offset )) $m"
+   done | sort -n | awk '{ printf "   [%d] = \"%s\",\n", $1, $2 }'
+   echo "};"
+   echo
+}
+
+# What arm64 asks from asm-generic/unistd.h (see arch/arm64/include/uapi/asm/unistd.h)
+# (__BITS_PER_LONG comes from the 64-bit host)
+aarch64="-D__ARCH_WANT_RENAMEAT -D__ARCH_WANT_NEW_STAT
+         -D__ARCH_WANT_SET_GET_RLIMIT -D__ARCH_WANT_TIME32_SYSCALLS -D__ARCH_WANT_SYS_CLONE3
+         -D__ARCH_WANT_MEMFD_SECRET"
+
+echo "// Generated by mksyscalls.sh, DO NOT EDIT"
+echo
+table x86_64  ""       asm/unistd_64.h
+table x32     "-D__X32_SYSCALL_BIT=0x40000000" asm/unistd_x32.h 0x40000000
+table i386    ""       asm/unistd_32.h
+table aarch64 "$aarch64" asm-generic/unistd.h
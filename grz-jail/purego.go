@@ -0,0 +1,92 @@
+//go:build !cgo
+
+package main
+
+// Without cgo, grz-jail runs programs with package jail, which has no
+// seccomp, namespaces, cgroups or compiler mode.
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/pauek/garzon/jail"
+)
+
+func die(msg string) {
+	if JSONReport {
+		report(jail.Result{Status: "Internal Error", Syscalls: map[string]int{}, Message: msg})
+	} else {
+		fmt.Fprintf(os.Stderr, "%s\n", msg)
+	}
+	os.Exit(2)
+}
+
+// report writes the result as grz-jail does (in text or JSON).
+func report(R jail.Result) {
+	if JSONReport {
+		data, _ := json.Marshal(R)
+		fmt.Fprintf(os.Stderr, "%s\n", data)
+		return
+	}
+	switch R.Status {
+	case "Ok":
+		fmt.Fprintf(os.Stderr, "Ok\n%.3f sec\n%.3f MB\n", float64(R.CpuMs)/1000, float64(R.MemoryKb)/1024)
+	case "Non-Zero Status":
+		fmt.Fprintf(os.Stderr, "Non-Zero Status\n%d\n", R.ExitCode)
+	default:
+		fmt.Fprintf(os.Stderr, "%s\n%s\n", R.Status, R.Reason)
+	}
+}
+
+func main() {
+	args := parseFlags()
+	if SeccompMode || Namespaces || CgroupParent != "" || CompileOutput != "" {
+		fmt.Fprintf(os.Stderr, "Options -s, -n, -g and -c need grz-jail built with cgo\n")
+		os.Exit(3)
+	}
+	spec := jail.Spec{
+		Dir:      args[0],
+		Accused:  AccusedMode,
+		Time:     MaxCpuSeconds,
+		WallTime: MaxWallSeconds,
+		IdleTime: MaxIdleSeconds,
+		Memory:   MaxMemory,
+		FileSize: MaxFileSize,
+		Output:   MaxOutput,
+		Uid:      SandboxUid,
+		Stdin:    os.Stdin,
+		Stdout:   os.Stdout,
+	}
+	if AccusedMode && PolicyFile != "" {
+		data, err := ioutil.ReadFile(PolicyFile)
+		if err != nil {
+			die(fmt.Sprintf("Cannot read file '%s': %s", PolicyFile, err))
+		}
+		spec.Policy = strings.Split(string(data), "\n")
+	}
+	openFile := func(path string) io.Writer {
+		f, err := os.Create(path)
+		if err != nil {
+			die(fmt.Sprintf("Couldn't open '%s': %s", path, err))
+		}
+		return f
+	}
+	if StderrFile != "" {
+		spec.Stderr = openFile(StderrFile)
+	}
+	if AuditFile != "" {
+		spec.Audit = openFile(AuditFile)
+	}
+	R, err := jail.Run(spec)
+	if err != nil {
+		die(err.Error())
+	}
+	report(R)
+	if R.Status != "Ok" {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,19 @@
+#include <stdio.h>
+#include <string.h>
+
+// Makes a syscall ('getpid') with the ABI in its input, "i386" (with
+// 'int 0x80') or "x32" (with the x32 bit in the number), or the native
+// one otherwise (x86_64 only)
+int main() {
+   char abi[16] = "";
+   long ret;
+   scanf("%15s", abi);
+   if (!strcmp(abi, "i386")) {
+      __asm__ volatile ("int $0x80" : "=a"(ret) : "a"(20) : "memory");
+   } else if (!strcmp(abi, "x32")) {
+      __asm__ volatile ("syscall" : "=a"(ret) : "a"(0x40000000 | 39) : "rcx", "r11", "memory");
+   } else {
+      __asm__ volatile ("syscall" : "=a"(ret) : "a"(39) : "rcx", "r11", "memory");
+   }
+   return 0;
+}
@@ -0,0 +1,29 @@
+#include <fcntl.h>
+#include <stdio.h>
+#include <sys/wait.h>
+#include <unistd.h>
+
+// Forks n children, all alive at the same time, which open the path
+// in its input ("<n> <path>"), to test the tracing of children
+int fds[2]; // (global, so that 'pipe2' has the same argument)
+
+int main() {
+   int n, i;
+   char path[256];
+   if (scanf("%d %255s", &n, path) != 2) return 1;
+   if (pipe(fds) < 0) return 1;
+   for (i = 0; i < n; i++) {
+      pid_t pid = fork();
+      if (pid < 0) return 2;
+      if (pid == 0) {
+         char c;
+         close(fds[1]);
+         read(fds[0], &c, 1); // (until all are forked)
+         open(path, O_RDONLY);
+         _exit(0);
+      }
+   }
+   close(fds[1]);
+   while (wait(NULL) > 0);
+   return 0;
+}
@@ -0,0 +1,9 @@
+#include <unistd.h>
+
+// Makes many syscalls (to test the audit log)
+int main() {
+   for (int i = 0; i < 1000; i++) {
+      write(1, "x", 1);
+   }
+   return 0;
+}
@@ -3,6 +3,6 @@
 using namespace std;
 
 int main() {
-   ofstream fout("/tmp/test");
+   ofstream fout("test"); // (in the working directory)
    fout << "Hi, there!" << endl;
 }
@@ -0,0 +1,12 @@
+#include <fcntl.h>
+#include <stdio.h>
+#include <string.h>
+
+// Opens the path in its input (to test policies)
+int main() {
+   char path[256];
+   if (fgets(path, sizeof(path), stdin) == NULL) return 1;
+   path[strcspn(path, "\n")] = 0;
+   open(path, O_RDONLY);
+   return 0;
+}
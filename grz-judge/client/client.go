@@ -144,11 +144,14 @@ func (C *Client) Logout(login string) (err error) {
 	return nil
 }
 
-func (C *Client) Submit(probid, filename string, data []byte) (id string, err error) {
+func (C *Client) Submit(probid, filename, lang string, data []byte) (id string, err error) {
 	var buff bytes.Buffer
 	w := multipart.NewWriter(&buff)
 	w.WriteField("username", C.Username)
 	w.WriteField("id", probid)
+	if lang != "" {
+		w.WriteField("lang", lang)
+	}
 	part, err := w.CreateFormFile("solution", filename)
 	if err != nil {
 		return "", fmt.Errorf("Cannot create form file: %s", err)
@@ -156,6 +156,7 @@ func (E *Evaluator) HandleSubmissions() {
 		sub.Resolved = time.Now()
 		sub.Veredict = *resp.Veredict
 		sub.Problem = nil
+		stats.Add(sub.ProblemID, &sub.Veredict)
 		queue.store(id)
 		queue.SendStatus(id, "Resolved")
 		fmt.Printf("\nREMOTE:\n%s\nLOCAL:\n", E.stderr.String())
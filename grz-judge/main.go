@@ -127,7 +127,7 @@ func submit(w http.ResponseWriter, req *http.Request) {
 		fmt.Fprintf(w, "ERROR: %s\n", err)
 		return
 	}
-	file, _, err := req.FormFile("solution")
+	file, header, err := req.FormFile("solution")
 	if err != nil {
 		fmt.Fprint(w, "ERROR: Cannot get solution file")
 		return
@@ -137,6 +137,16 @@ func submit(w http.ResponseWriter, req *http.Request) {
 		fmt.Fprint(w, "ERROR: Cannot read solution file")
 		return
 	}
+	L, err := getLanguage(req.FormValue("lang"), header.Filename, solutionBytes)
+	if err != nil {
+		fmt.Fprintf(w, "ERROR: %s\n", err)
+		return
+	}
+	if !problem.Allows(L.Name) {
+		fmt.Fprintf(w, "ERROR: Language '%s' not allowed in '%s' (use %s)\n",
+			L.Name, id, strings.Join(problem.Languages, " or "))
+		return
+	}
 	user := req.Header.Get("user")
 	if user != username {
 		log.Printf("Warning: different 'user' and 'username' ('%s' vs '%s')", user, username)
@@ -157,7 +167,7 @@ func submit(w http.ResponseWriter, req *http.Request) {
 	solution := string(solutionBytes)
 	fmt.Println(solution)
 	
-	ID := queue.Add(username, id, problem, solution)
+	ID := queue.Add(username, id, problem, L.Name, solution)
 	fmt.Fprintf(w, "%s", ID)
 	return
 }
@@ -198,12 +208,25 @@ func veredict(w http.ResponseWriter, req *http.Request) {
 	}
 	V := sub.Veredict
 	fmt.Fprintf(w, "%s\n", V.Message)
+	if len(V.Warnings) > 0 {
+		fmt.Fprintf(w, "\nWarnings:\n")
+		for _, warning := range V.Warnings {
+			fmt.Fprintf(w, "%s\n", warning)
+		}
+	}
 	if V.Message != "Accepted" && V.Details.Obj != nil {
 		fmt.Fprintf(w, "\n%v", V.Details.Obj)
+	} else if T, ok := V.Details.Obj.(interface{ Table() string }); ok {
+		fmt.Fprintf(w, "\n%s", T.Table()) // (time and memory of each test)
 	}
 	queue.Delete(id)
 }
 
+func compileStats(w http.ResponseWriter, req *http.Request) {
+	id := req.URL.Path[len("/stats/"):]
+	stats.Write(w, id)
+}
+
 func handleAndShowFlags(flags map[string]*bool) {
 	for name, active := range flags {
 		if *active {
@@ -263,6 +286,7 @@ func main() {
 	http.HandleFunc("/list", wAuth(list))
 	http.HandleFunc("/status/", status)
 	http.HandleFunc("/veredict/", wAuth(veredict))
+	http.HandleFunc("/stats/", wAuth(compileStats))
 
 	Url := fmt.Sprintf("%s:%d", Server, ListenPort)
 	err := http.ListenAndServe(Url, nil)
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pauek/garzon/eval"
+)
+
+// postSolution posts a solution to /submit and gives the response.
+func postSolution(t *testing.T, id, filename, solution string) string {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	form.WriteField("id", id)
+	part, err := form.CreateFormFile("solution", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write([]byte(solution))
+	form.Close()
+	req, err := http.NewRequest("POST", "/submit", &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	w := httptest.NewRecorder()
+	submit(w, req)
+	return w.Body.String()
+}
+
+func TestSubmitLanguage(t *testing.T) {
+	root, err := ioutil.TempDir("", "grz-judge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	dir := filepath.Join(root, "Sum.prog")
+	files := map[string]string{
+		"title":       "Sum",
+		"solution.cc": "int main() {}\n",
+		"languages":   "C++\n",
+	}
+	os.Mkdir(dir, 0700)
+	for name, contents := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer func(path string) { eval.GrzPath = path }(eval.GrzPath)
+	eval.GrzPath = root
+	Mode["files"] = true
+	defer delete(Mode, "files")
+
+	out := postSolution(t, "Sum", "sum.go", "package main\n\nfunc main() {}\n")
+	if want := "ERROR: Language 'Go' not allowed in 'Sum' (use C++)"; !strings.HasPrefix(out, want) {
+		t.Errorf("A solution in Go should be rejected (got '%s')", out)
+	}
+	if queue.Pending() != 0 {
+		t.Errorf("A rejected solution shouldn't be queued")
+	}
+}
@@ -31,12 +31,13 @@ func (Q *Queue) Pending() int {
 	return len(Q.inprogress)
 }
 
-func (Q *Queue) Add(user string, pid string, problem *eval.Problem, sol string) (ID string) {
+func (Q *Queue) Add(user string, pid string, problem *eval.Problem, lang, sol string) (ID string) {
 	ID = db.NewUUID()
 	Q.Mutex.Lock()
 	Q.inprogress[ID] = &eval.Submission{
 		User:      user,
 		ProblemID: pid,
+		Lang:      lang,
 		Solution:  sol,
 		Submitted: time.Now(),
 	}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/pauek/garzon/eval"
+	prog "github.com/pauek/garzon/eval/programming"
+)
+
+var stats Stats
+
+// Stats counts the kinds of compilation errors of each problem (since
+// the judge started), to know which are the most common.
+type Stats struct {
+	Mutex  sync.Mutex
+	errors map[string]map[string]int // problem ID -> error kind -> count
+}
+
+type StatsEntry struct {
+	Kind  string
+	Count int
+}
+
+type byCount []StatsEntry
+
+func (s byCount) Len() int           { return len(s) }
+func (s byCount) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byCount) Less(i, j int) bool { return s[i].Count > s[j].Count }
+
+func (S *Stats) Add(probid string, V *eval.Veredict) {
+	var diags *prog.CompilationDiagnostics
+	switch d := V.Details.Obj.(type) {
+	case *prog.CompilationDiagnostics:
+		diags = d
+	case prog.CompilationDiagnostics:
+		diags = &d
+	default:
+		return
+	}
+	S.Mutex.Lock()
+	defer S.Mutex.Unlock()
+	if S.errors == nil {
+		S.errors = make(map[string]map[string]int)
+	}
+	if S.errors[probid] == nil {
+		S.errors[probid] = make(map[string]int)
+	}
+	for _, e := range diags.Errors() {
+		S.errors[probid][e.Kind()]++
+	}
+}
+
+func (S *Stats) MostCommonErrors(probid string) (entries []StatsEntry) {
+	S.Mutex.Lock()
+	defer S.Mutex.Unlock()
+	for kind, count := range S.errors[probid] {
+		entries = append(entries, StatsEntry{kind, count})
+	}
+	sort.Sort(byCount(entries))
+	return
+}
+
+func (S *Stats) Write(w io.Writer, probid string) {
+	for _, e := range S.MostCommonErrors(probid) {
+		fmt.Fprintf(w, "%6d  %s\n", e.Count, e.Kind)
+	}
+}
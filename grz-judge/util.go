@@ -5,6 +5,7 @@ import (
 	"github.com/pauek/garzon/db"
 	"github.com/pauek/garzon/eval"
 	_ "github.com/pauek/garzon/eval/programming"
+	"github.com/pauek/garzon/eval/programming/lang"
 	"io"
 	"log"
 	"os"
@@ -63,3 +64,20 @@ func listProblems(w io.Writer) {
 		})
 	}
 }
+
+// getLanguage finds the language of a solution, given by name or
+// detected from the filename and contents.
+func getLanguage(name, filename string, solution []byte) (*lang.Language, error) {
+	if name != "" {
+		L := lang.Find(name)
+		if L == nil {
+			return nil, fmt.Errorf("Unknown language '%s'", name)
+		}
+		return L, nil
+	}
+	L := lang.Detect(filename, solution)
+	if L == nil {
+		return nil, fmt.Errorf("Cannot determine the language of '%s' (use --lang)", filename)
+	}
+	return L, nil
+}
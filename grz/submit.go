@@ -1,20 +1,21 @@
 package main
 
 import (
-	"bytes"
 	"flag"
 	"fmt"
 	"strings"
 	"io/ioutil"
+	"os"
 	"path/filepath"
 )
 
-const u_submit = `grz submit <ProblemID> <filename>`
+const u_submit = `grz submit [--lang <language>] <ProblemID> <filename>`
 
 func submit(args []string) {
-	var url string
+	var url, lang string
 	fset := flag.NewFlagSet("submit", flag.ExitOnError)
 	fset.StringVar(&url, "judge", "", "URL for the Judge")
+	fset.StringVar(&lang, "lang", "", "Language (by default, the Judge detects it)")
 	fset.Parse(args)
 
 	if url != "" {
@@ -26,20 +27,12 @@ func submit(args []string) {
 	}
 
 	probid, filename := checkTwoArgs("submit", fset.Args())
-	
-	ext := filepath.Ext(filename)
-	if ext == "" {
-		_errx("File has no extension: cannot determine language")
-	}
 
-	var buf bytes.Buffer
-	fmt.Fprintf(&buf, "%s\n", ext)
 	data, err := ioutil.ReadFile(filename)
 	if err != nil {
 		_errx("Cannot read file '%s'", filename)
 	}
-	buf.Write(data)
-	resp, err := client.Submit(probid, filename, buf.Bytes())
+	resp, err := client.Submit(probid, filepath.Base(filename), lang, data)
 	if err != nil {
 		_errx("Submission error: %s", err)
 	}
@@ -61,5 +54,32 @@ func submit(args []string) {
 		_errx("Cannot get veredict: %s", err)
 	}
 	fmt.Printf("\r                                         \r")
-	fmt.Print(veredict)
+	fmt.Print(highlight(veredict))
+}
+
+const (
+	red     = "\033[1;31m"
+	magenta = "\033[1;35m"
+	green   = "\033[1;32m"
+	reset   = "\033[0m"
+)
+
+// highlight colors the compiler diagnostics in the veredict (errors,
+// warnings and the caret under the column), if stdout is a terminal.
+func highlight(veredict string) string {
+	if !isTerminal(os.Stdout) {
+		return veredict
+	}
+	lines := strings.Split(veredict, "\n")
+	for i, line := range lines {
+		switch {
+		case strings.Contains(line, ": error: "):
+			lines[i] = red + line + reset
+		case strings.Contains(line, ": warning: "):
+			lines[i] = magenta + line + reset
+		case strings.HasSuffix(line, "^") && strings.Contains(line, "| "):
+			lines[i] = line[:len(line)-1] + green + "^" + reset
+		}
+	}
+	return strings.Join(lines, "\n")
 }
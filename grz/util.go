@@ -43,3 +43,11 @@ func isOpen() bool {
 	}
 	return open
 }
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,111 @@
+// Package jail runs a program tracing its syscalls, as grz-jail
+// does, but in Go (with ptrace, rlimits and /proc), so that it can be
+// used as a library and tested.
+//
+// As in grz-jail, a "model" program is run first, and the
+// representations of its syscalls are saved in the file '.syscalls'
+// of the directory of the program. Then, the "accused" program (with
+// Spec.Accused) can only make the syscalls in that file (or those
+// allowed by the policy). There is no seccomp, namespaces or cgroups
+// here (see grz-jail for those), and only programs of the native ABI
+// can run.
+package jail
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// A Spec says which program to run and how. Zero limits take the
+// default values of grz-jail.
+type Spec struct {
+	Dir      string   // directory of the program ('exe') and '.syscalls'
+	Accused  bool     // accused mode (otherwise, model mode)
+	Policy   []string // 'allow' and 'deny' rules, for the accused (as grz-jail -P)
+	Time     float64  // CPU seconds
+	WallTime float64  // wall clock seconds
+	IdleTime float64  // seconds without using the CPU (0: no limit)
+	Memory   int      // bytes of address space
+	FileSize int      // bytes of files written
+	Output   int      // bytes of standard output
+	Uid      int      // user and group of the program (0: ours), as root
+
+	Stdin  io.Reader // (nil for /dev/null)
+	Stdout io.Writer // (nil to discard it)
+	Stderr io.Writer // up to FileSize bytes (nil to discard it)
+	Audit  io.Writer // log of the last syscalls, as grz-jail -A (can be nil)
+}
+
+// A Result is what grz-jail reports in JSON (grz-jail -json).
+type Result struct {
+	Status    string         `json:"status"`
+	Reason    string         `json:"reason"`
+	ExitCode  int            `json:"exit_code"`
+	Signal    int            `json:"signal"`
+	CpuMs     int            `json:"cpu_ms"`
+	WallMs    int            `json:"wall_ms"`
+	MemoryKb  int            `json:"memory_kb"`
+	PeakRssKb int            `json:"peak_rss_kb"`
+	Forbidden string         `json:"forbidden"`
+	Syscalls  map[string]int `json:"syscalls"`
+	Message   string         `json:"message"`
+}
+
+const (
+	defaultTime     = 2.0
+	defaultMemory   = 64 * 1024 * 1024
+	defaultFileSize = 1024
+	defaultOutput   = 64 * 1024 * 1024
+)
+
+func (S *Spec) setDefaults() {
+	if S.Time <= 0 {
+		S.Time = defaultTime
+	}
+	if S.WallTime <= 0 {
+		S.WallTime = 2*S.Time + 1
+	}
+	if S.Memory <= 0 {
+		S.Memory = defaultMemory
+	}
+	if S.FileSize <= 0 {
+		S.FileSize = defaultFileSize
+	}
+	if S.Output <= 0 {
+		S.Output = defaultOutput
+	}
+}
+
+// SyscallsFile is the file (in Spec.Dir) with the syscalls of the model.
+const SyscallsFile = ".syscalls"
+
+// Run runs the program 'exe' in spec.Dir. Failures of the program
+// are in the Result ("Execution Error", "Non-Zero Status", etc.),
+// and an error means that the program couldn't be judged (an
+// "Internal Error" of grz-jail).
+func Run(spec Spec) (Result, error) {
+	spec.setDefaults()
+	exe := filepath.Join(spec.Dir, "exe")
+	if _, err := os.Stat(exe); err != nil {
+		return Result{}, fmt.Errorf("Cannot find executable '%s'", exe)
+	}
+	t := newTracer(spec)
+	if spec.Accused {
+		if err := t.readSyscalls(); err != nil {
+			return Result{}, err
+		}
+		if err := t.readPolicy(spec.Policy); err != nil {
+			return Result{}, err
+		}
+	} else {
+		f, err := os.Create(filepath.Join(spec.Dir, SyscallsFile))
+		if err != nil {
+			return Result{}, fmt.Errorf("Couldn't open '%s': %s", SyscallsFile, err)
+		}
+		defer f.Close()
+		t.record = f
+	}
+	return t.run(exe)
+}
@@ -0,0 +1,171 @@
+package jail
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// The programs are those of grz-jail/test/cc
+const ccDir = "../grz-jail/test/cc"
+
+// compile compiles a program of ccDir to 'exe' in a new directory.
+func compile(t *testing.T, name string) string {
+	compiler := "gcc"
+	if strings.HasSuffix(name, ".cc") {
+		compiler = "g++"
+	}
+	if _, err := exec.LookPath(compiler); err != nil {
+		t.Skipf("No compiler '%s'", compiler)
+	}
+	dir, err := ioutil.TempDir("", "jail")
+	if err != nil {
+		t.Fatalf("Cannot create directory: %s", err)
+	}
+	out, err := exec.Command(compiler, "-static", "-o", filepath.Join(dir, "exe"),
+		filepath.Join(ccDir, name)).CombinedOutput()
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Skipf("Cannot compile '%s': %s\n%s", name, err, out)
+	}
+	return dir
+}
+
+func run(t *testing.T, spec Spec) Result {
+	R, err := Run(spec)
+	if err != nil {
+		if strings.Contains(err.Error(), "operation not permitted") {
+			t.Skipf("Cannot trace: %s", err)
+		}
+		t.Fatalf("Run failed: %s", err)
+	}
+	return R
+}
+
+func TestPrograms(t *testing.T) {
+	tests := []struct {
+		file, status, reason string
+	}{
+		{"inf_loop.c", "Execution Error", "Time Limit Exceeded"},
+		{"mem_sink.cc", "Execution Error", "Memory Limit Exceeded"},
+		{"segfault.c", "Execution Error", "Segmentation Fault"},
+		{"sigint.c", "Execution Error", "Interrupted"},
+		{"good_vector_1e6.cc", "Ok", ""},
+		{"bad_vector_1e8.cc", "Execution Error", "Memory Limit Exceeded"},
+	}
+	for _, test := range tests {
+		dir := compile(t, test.file)
+		defer os.RemoveAll(dir)
+		R := run(t, Spec{Dir: dir, Time: 1, WallTime: 30}) // (the machine might be busy)
+		if R.Status != test.status || R.Reason != test.reason {
+			t.Errorf("%s: expected '%s' (%s), got '%s' (%s)",
+				test.file, test.status, test.reason, R.Status, R.Reason)
+		}
+	}
+}
+
+func TestModelAndAccused(t *testing.T) {
+	dir := compile(t, "good_vector_1e6.cc")
+	defer os.RemoveAll(dir)
+	if R := run(t, Spec{Dir: dir}); R.Status != "Ok" {
+		t.Fatalf("The model should be Ok (is '%s')", R.Status)
+	}
+	if R := run(t, Spec{Dir: dir, Accused: true}); R.Status != "Ok" {
+		t.Errorf("The accused (same as the model) should be Ok (is '%s': %s)", R.Status, R.Reason)
+	}
+
+	// open_file makes syscalls that the model doesn't
+	exe := compile(t, "open_file.cc")
+	defer os.RemoveAll(exe)
+	if err := os.Rename(filepath.Join(exe, "exe"), filepath.Join(dir, "exe")); err != nil {
+		t.Fatalf("Cannot move the accused: %s", err)
+	}
+	var audit bytes.Buffer
+	R := run(t, Spec{Dir: dir, Accused: true, Audit: &audit})
+	if R.Status != "Execution Error" || !strings.HasPrefix(R.Reason, "Forbidden Syscall") {
+		t.Errorf("The accused should make a forbidden syscall (is '%s': %s)", R.Status, R.Reason)
+	}
+	if R.Forbidden == "" || !strings.Contains(audit.String(), R.Forbidden+" FORBIDDEN") {
+		t.Errorf("The forbidden syscall '%s' should be in the audit log", R.Forbidden)
+	}
+
+	// ... unless the policy allows everything (in the directory)
+	R = run(t, Spec{Dir: dir, Accused: true, Policy: []string{"allow *"}})
+	if R.Status != "Ok" {
+		t.Errorf("The policy should allow the accused (is '%s': %s)", R.Status, R.Reason)
+	}
+}
+
+func TestGlobRegexp(t *testing.T) {
+	tests := []struct {
+		pattern, repr string
+		match         bool
+	}{
+		{`open("*.txt")`, `open("data.txt")`, true},
+		{`open("*.txt")`, `open("/etc/a.txt")`, false}, // ('*' doesn't match '/')
+		{`open("/*/*.txt")`, `open("/etc/a.txt")`, true},
+		{`open("/etc?a.txt")`, `open("/etc/a.txt")`, false},
+		{`open("/etc[!a]a.txt")`, `open("/etc/a.txt")`, false},
+		{`open("/etc[+-0]a.txt")`, `open("/etc/a.txt")`, false},
+		{`open("[+-0]a.txt")`, `open("-a.txt")`, true},
+		{`open("*.txt")`, `open("data.dat")`, false},
+		{`write(?,_,_)`, `write(1,_,_)`, true},
+		{`write([!1],_,_)`, `write(1,_,_)`, false},
+		{`write([12],_,_)`, `write(2,_,_)`, true},
+		{`mmap\(*`, `mmap(*`, true},
+	}
+	for _, test := range tests {
+		re, err := globRegexp(test.pattern)
+		if err != nil {
+			t.Errorf("Pattern '%s': %s", test.pattern, err)
+			continue
+		}
+		if re.MatchString(test.repr) != test.match {
+			t.Errorf("Pattern '%s' with '%s' should give %v", test.pattern, test.repr, test.match)
+		}
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	tr := newTracer(Spec{})
+	tr.syscalls[`open("a")`] = true
+	err := tr.readPolicy([]string{"# comment", `allow open("*.txt")`, `deny open("a")`})
+	if err != nil {
+		t.Fatalf("Cannot read policy: %s", err)
+	}
+	if !tr.allowed(`open("b.txt")`) || tr.allowed(`open("a")`) || tr.allowed(`open("b")`) {
+		t.Errorf("Wrong policy")
+	}
+	if err := tr.readPolicy([]string{`allow open("/proc/self/*/*/*")`, `allow open("*/*")`}); err != nil {
+		t.Fatalf("Cannot read policy: %s", err)
+	}
+	for _, path := range []string{"/proc/self/root/etc/passwd", "/proc/1/cwd/a", "a/../../b"} {
+		if tr.allowed(`open("` + path + `")`) {
+			t.Errorf("No pattern should allow '%s'", path)
+		}
+	}
+	if err := tr.readPolicy([]string{"permit *"}); err == nil {
+		t.Errorf("Rules should start with 'allow' or 'deny'")
+	}
+}
+
+func TestAuditRing(t *testing.T) {
+	var audit bytes.Buffer
+	tr := newTracer(Spec{Audit: &audit})
+	for i := 0; i < 3*auditLines/2; i++ {
+		tr.auditAdd(fmt.Sprintf("%d\n", i))
+	}
+	tr.auditFinish()
+	lines := strings.Split(strings.TrimRight(audit.String(), "\n"), "\n")
+	if len(lines) != auditLines {
+		t.Fatalf("The audit log should have %d lines (has %d)", auditLines, len(lines))
+	}
+	if want := fmt.Sprint(auditLines / 2); lines[0] != want || lines[auditLines-1] != fmt.Sprint(3*auditLines/2-1) {
+		t.Errorf("The audit log should have the last lines in order (starts with '%s')", lines[0])
+	}
+}
@@ -0,0 +1,25 @@
+#!/bin/sh
+#
+# Generates the table of syscall names of an ABI from the tables of
+# grz-jail (../grz-jail/syscalls.h):
+#
+#    ./mksyscalls.sh x86_64 amd64 > zsyscalls_amd64.go
+#    ./mksyscalls.sh aarch64 arm64 > zsyscalls_arm64.go
+#
+
+abi=$1 goarch=$2
+{
+echo "// Generated by mksyscalls.sh $abi $goarch, DO NOT EDIT"
+echo
+echo "package jail"
+echo
+echo "const nativeABI = \"$abi\""
+echo
+echo "var syscallNames = [...]string{"
+awk -v table="${abi}_syscalls" '
+   $0 ~ "^const char \\*" table "\\[\\]" { inside = 1; next }
+   inside && /^};/ { inside = 0 }
+   inside { gsub(/[\[\],]/, ""); printf "\t%s: %s,\n", $1, $3 }
+' ../grz-jail/syscalls.h
+echo "}"
+} | gofmt
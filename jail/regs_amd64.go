@@ -0,0 +1,41 @@
+package jail
+
+import "syscall"
+
+const x32SyscallBit = 0x40000000
+
+// On x86-64, the number of the syscall is in orig_rax, and the
+// arguments in rdi, rsi and rdx.
+type regs struct {
+	syscall.PtraceRegs
+}
+
+func getRegs(pid int) (*regs, error) {
+	var r regs
+	if err := syscall.PtraceGetRegs(pid, &r.PtraceRegs); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+func (r *regs) number() uint64  { return r.Orig_rax }
+func (r *regs) args() [3]uint64 { return [3]uint64{r.Rdi, r.Rsi, r.Rdx} }
+func (r *regs) result() int64   { return int64(r.Rax) }
+
+// abi tells if a syscall is a 32-bit one (32-bit code, or 'int 0x80'
+// or 'sysenter' in 64-bit code), or an x32 one (with the x32 bit in
+// the number).
+func (r *regs) abi(pid int) string {
+	if r.Cs == 0x23 {
+		return "i386"
+	}
+	var insn [2]byte
+	n, _ := syscall.PtracePeekText(pid, uintptr(r.Rip-2), insn[:])
+	if n == 2 && (insn == [2]byte{0xcd, 0x80} || insn == [2]byte{0x0f, 0x34}) {
+		return "i386"
+	}
+	if int64(r.Orig_rax) >= 0 && r.Orig_rax&x32SyscallBit != 0 {
+		return "x32"
+	}
+	return nativeABI
+}
@@ -0,0 +1,29 @@
+package jail
+
+import "syscall"
+
+// On aarch64, the number of the syscall is in x8, and the arguments
+// in x0, x1 and x2 (x0 is the result at the exit).
+type regs struct {
+	syscall.PtraceRegs
+}
+
+func getRegs(pid int) (*regs, error) {
+	var r regs
+	if err := syscall.PtraceGetRegs(pid, &r.PtraceRegs); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+func (r *regs) number() uint64  { return r.Regs[8] }
+func (r *regs) args() [3]uint64 { return [3]uint64{r.Regs[0], r.Regs[1], r.Regs[2]} }
+func (r *regs) result() int64   { return int64(r.Regs[0]) }
+
+// abi tells if the process runs 32-bit (arm) code (in PSTATE).
+func (r *regs) abi(pid int) string {
+	if r.Pstate&0x10 != 0 {
+		return "arm"
+	}
+	return nativeABI
+}
@@ -0,0 +1,300 @@
+package jail
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// The types of the (first three) arguments of syscalls, as in
+// grz-jail:
+//
+//	i  integer
+//	f  filename
+//	.  ignore
+//	*  ignore all starting from this one
+//
+// Syscalls without types have all of them shown in hexadecimal.
+var argTypes = map[string]string{
+	// Syscalls with filenames in them
+	"open":     "f*",
+	"creat":    "f*",
+	"unlink":   "f",
+	"access":   "f*",
+	"truncate": "f*",
+	"stat":     "f*",
+	"lstat":    "f*",
+	"readlink": "f*",
+	"chmod":    "fi",
+
+	// Syscalls with file descriptors
+	"read":      "i..",
+	"write":     "i..",
+	"close":     "i",
+	"lseek":     "i..",
+	"dup":       "i",
+	"dup2":      "ii",
+	"ftruncate": "i.",
+	"fstat":     "i.",
+	"readv":     "i..",
+	"writev":    "i..",
+	"pread64":   "i...",
+	"pwrite64":  "i...",
+	"fcntl":     "ii*",
+	"ioctl":     "ii",
+	"fchmod":    "ii",
+
+	// Others
+	"exit":            "i",
+	"exit_group":      "i",
+	"arch_prctl":      "i.",
+	"getpid":          "",
+	"getuid":          "",
+	"brk":             ".",
+	"personality":     "i",
+	"getresuid":       "*",
+	"mmap":            "*",
+	"munmap":          "*",
+	"uname":           ".",
+	"gettid":          "",
+	"set_thread_area": ".",
+	"get_thread_area": ".",
+	"set_tid_address": ".",
+	"time":            ".",
+	"alarm":           "i",
+	"pause":           "",
+	"nanosleep":       "*",
+
+	// Go
+	"getrlimit":      "i.i",
+	"rt_sigprocmask": "i..",
+	"rt_sigaction":   "i*",
+	"gettimeofday":   ".i.",
+	"sigaltstack":    "*",
+	"clone":          "*",
+	"futex":          ".ii",
+
+	// Threads & newer libc
+	"clone3":            "*",
+	"set_robust_list":   "..",
+	"rseq":              "*",
+	"sched_getaffinity": "i*",
+	"sched_yield":       "",
+	"tgkill":            "..i",
+	"prctl":             "i*",
+	"madvise":           "*",
+	"mprotect":          "*",
+	"getrandom":         ".ii",
+	"prlimit64":         "ii*",
+	"wait4":             "i*",
+	"clock_gettime":     "i.",
+	"rt_sigreturn":      "",
+	"openat":            "if*",
+	"newfstatat":        "if*",
+	"readlinkat":        "if*",
+}
+
+func syscallName(nr uint64) string {
+	if nr < uint64(len(syscallNames)) {
+		return syscallNames[nr]
+	}
+	return ""
+}
+
+// repr is the representation of a syscall (e.g. 'open("data")'),
+// which has the ABI in front if it is not the native one.
+func repr(abi, name string, nr uint64, args [3]uint64, filename func(uint64) (string, error)) (string, error) {
+	var b strings.Builder
+	if abi != nativeABI {
+		fmt.Fprintf(&b, "%s:", abi)
+	}
+	types, ok := argTypes[name]
+	if name == "" || abi != nativeABI {
+		name, types, ok = fmt.Sprintf("syscall_%d", nr), "", false
+	}
+	if !ok {
+		types = "___"
+	}
+	fmt.Fprintf(&b, "%s(", name)
+	for i := 0; i < len(types) && i < len(args); i++ {
+		if types[i] == '*' {
+			break
+		}
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		switch types[i] {
+		case 'i':
+			fmt.Fprintf(&b, "%d", args[i])
+		case 'f':
+			f, err := filename(args[i])
+			if err != nil {
+				return "", err
+			}
+			fmt.Fprintf(&b, "\"%s\"", f)
+		case '.':
+			b.WriteByte('_')
+		default:
+			fmt.Fprintf(&b, "%x", args[i])
+		}
+	}
+	b.WriteByte(')')
+	return b.String(), nil
+}
+
+// readSyscalls reads the syscalls of the model.
+func (t *tracer) readSyscalls() error {
+	path := filepath.Join(t.spec.Dir, SyscallsFile)
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("Cannot read file '%s': %s", path, err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		t.syscalls[scanner.Text()] = true
+	}
+	return scanner.Err()
+}
+
+// Policy
+
+type rule struct {
+	allow   bool
+	pattern *regexp.Regexp
+}
+
+func (t *tracer) readPolicy(lines []string) error {
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var r rule
+		switch {
+		case strings.HasPrefix(line, "allow ") || strings.HasPrefix(line, "allow\t"):
+			r.allow, line = true, line[5:]
+		case strings.HasPrefix(line, "deny ") || strings.HasPrefix(line, "deny\t"):
+			r.allow, line = false, line[4:]
+		default:
+			return fmt.Errorf("Wrong policy line '%s': expected 'allow' or 'deny'", line)
+		}
+		pattern := strings.TrimSpace(line)
+		re, err := globRegexp(pattern)
+		if err != nil {
+			return fmt.Errorf("Wrong pattern '%s': %s", pattern, err)
+		}
+		r.pattern = re
+		t.rules = append(t.rules, r)
+	}
+	return nil
+}
+
+// globRegexp translates a pattern of fnmatch with FNM_PATHNAME (as in
+// grz-jail: '*', '?' and '[...]' don't match '/') to a regexp.
+func globRegexp(pattern string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			b.WriteString("[^/]*")
+		case '?':
+			b.WriteString("[^/]")
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+				b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+			} else {
+				b.WriteString(`\\`)
+			}
+		case '[':
+			j := strings.IndexByte(pattern[i+1:], ']')
+			if j < 0 {
+				b.WriteString(`\[`)
+				break
+			}
+			b.WriteString(bracketRegexp(pattern[i+1 : i+1+j]))
+			i += j + 1
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+// bracketRegexp translates the inside of a bracket expression (e.g.
+// "!a-z"), leaving '/' out of it.
+func bracketRegexp(class string) string {
+	negate := strings.HasPrefix(class, "!")
+	if negate {
+		class = class[1:]
+	}
+	var b strings.Builder
+	span := func(lo, hi byte) {
+		if lo <= hi {
+			fmt.Fprintf(&b, `\x{%x}-\x{%x}`, lo, hi)
+		}
+	}
+	for i := 0; i < len(class); {
+		lo, hi := class[i], class[i]
+		if i+2 < len(class) && class[i+1] == '-' {
+			hi = class[i+2]
+			i += 3
+		} else {
+			i++
+		}
+		if lo <= '/' && '/' <= hi {
+			span(lo, '/'-1)
+			span('/'+1, hi)
+		} else {
+			span(lo, hi)
+		}
+	}
+	if negate {
+		return `[^/` + b.String() + "]"
+	}
+	if b.Len() == 0 {
+		return `\b\B` // (matches nothing)
+	}
+	return "[" + b.String() + "]"
+}
+
+// unsafePath tells if the representation of a syscall has a filename
+// with '..' or through the root or working directory of a process in
+// /proc, which can lead anywhere (as in grz-jail, no pattern allows
+// them).
+func unsafePath(repr string) bool {
+	if strings.Contains(repr, "..") {
+		return true
+	}
+	if !strings.Contains(repr, "/proc/") {
+		return false
+	}
+	for _, s := range []string{`/root/`, `/root"`, `/cwd/`, `/cwd"`} {
+		if strings.Contains(repr, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *tracer) policyMatch(allow bool, repr string) bool {
+	for _, r := range t.rules {
+		if r.allow == allow && r.pattern.MatchString(repr) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *tracer) allowed(repr string) bool {
+	if t.policyMatch(false, repr) {
+		return false
+	}
+	return t.syscalls[repr] || (!unsafePath(repr) && t.policyMatch(true, repr))
+}
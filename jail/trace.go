@@ -0,0 +1,657 @@
+package jail
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const (
+	ptraceExitKill = 0x100000 // PTRACE_O_EXITKILL (not in package syscall)
+
+	ptraceOptions = syscall.PTRACE_O_TRACESYSGOOD | syscall.PTRACE_O_TRACEEXEC |
+		syscall.PTRACE_O_TRACEFORK | syscall.PTRACE_O_TRACEVFORK |
+		syscall.PTRACE_O_TRACECLONE | ptraceExitKill
+
+	tickerPeriod = 10 * time.Millisecond
+	clockTicks   = 100 // USER_HZ, the unit of times in /proc/<pid>/stat
+	pageSize     = 4096
+	maxFilename  = 4096
+	auditLines   = 100 // (as grz-jail)
+)
+
+type tracee struct {
+	started   bool   // (new tracees start with a SIGSTOP)
+	inSyscall bool   // between the entry and the exit of a syscall
+	sys       uint64 // number of the current syscall
+}
+
+type tracer struct {
+	spec     Spec
+	syscalls map[string]bool // of the model
+	rules    []rule          // of the policy
+	record   *os.File        // the syscalls of the model are written here
+	audit    []string        // ring of the last auditLines (written at the end)
+	nAudit   int
+
+	pid     int
+	tracees map[int]*tracee
+	start   time.Time
+	result  Result
+	peakKb  int // VmPeak (sampled)
+	ruUsage syscall.Rusage
+
+	mu     sync.Mutex
+	failed bool // the status is already decided (and the accused killed)
+	reaped bool // the accused is gone (its pid might be reused)
+}
+
+func newTracer(spec Spec) *tracer {
+	return &tracer{
+		spec:     spec,
+		syscalls: make(map[string]bool),
+		tracees:  make(map[int]*tracee),
+		result:   Result{Syscalls: make(map[string]int)},
+	}
+}
+
+func (t *tracer) elapsedMs() int {
+	return int(time.Since(t.start) / time.Millisecond)
+}
+
+// fail decides the status of the accused (only the first time) and
+// kills it. It can be called from any goroutine.
+func (t *tracer) fail(status, reason string) {
+	t.mu.Lock()
+	if t.failed || t.reaped {
+		t.mu.Unlock()
+		return
+	}
+	t.failed = true
+	t.result.Status, t.result.Reason = status, reason
+	t.mu.Unlock()
+	t.samplePeak()
+	syscall.Kill(-t.pid, syscall.SIGKILL)
+}
+
+func (t *tracer) execError(reason string) {
+	t.fail("Execution Error", reason)
+}
+
+func (t *tracer) hasFailed() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.failed
+}
+
+// samplePeak reads VmPeak from /proc/<pid>/status.
+func (t *tracer) samplePeak() {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/status", t.pid))
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "VmPeak:") {
+			fields := strings.Fields(line[len("VmPeak:"):])
+			if len(fields) > 0 {
+				if kb, err := strconv.Atoi(fields[0]); err == nil {
+					t.mu.Lock()
+					if kb > t.peakKb {
+						t.peakKb = kb
+					}
+					t.mu.Unlock()
+				}
+			}
+			return
+		}
+	}
+}
+
+// cpuMs reads the CPU time (so far) from /proc/<pid>/stat.
+func (t *tracer) cpuMs() (int, error) {
+	data, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", t.pid))
+	if err != nil {
+		return 0, err
+	}
+	s := string(data)
+	i := strings.LastIndex(s, ")") // (the name might have spaces)
+	if i < 0 {
+		return 0, fmt.Errorf("Cannot parse /proc/%d/stat", t.pid)
+	}
+	fields := strings.Fields(s[i+1:])
+	if len(fields) < 13 {
+		return 0, fmt.Errorf("Cannot parse /proc/%d/stat", t.pid)
+	}
+	utime, err1 := strconv.ParseUint(fields[11], 10, 64)
+	stime, err2 := strconv.ParseUint(fields[12], 10, 64)
+	if err1 != nil || err2 != nil {
+		return 0, fmt.Errorf("Cannot parse /proc/%d/stat", t.pid)
+	}
+	return int((utime + stime) * 1000 / clockTicks), nil
+}
+
+// checkLimits checks the wall clock, CPU and idle times periodically
+// until 'done' is closed (as the timer of grz-jail).
+func (t *tracer) checkLimits(done <-chan bool, wg *sync.WaitGroup) {
+	defer wg.Done()
+	ticker := time.NewTicker(tickerPeriod)
+	defer ticker.Stop()
+	maxWall := int(t.spec.WallTime * 1000)
+	maxCpu := int(t.spec.Time * 1000)
+	maxIdle := int(t.spec.IdleTime * 1000)
+	lastCpu, lastBusy := -1, 0
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		wall := t.elapsedMs()
+		if wall > maxWall {
+			t.execError("Wall Time Limit Exceeded")
+			return
+		}
+		cpu, err := t.cpuMs()
+		if err != nil {
+			continue // (it might have exited)
+		}
+		if cpu > maxCpu {
+			t.execError("Time Limit Exceeded")
+			return
+		}
+		if cpu != lastCpu {
+			lastCpu, lastBusy = cpu, wall
+		} else if maxIdle > 0 && wall-lastBusy > maxIdle {
+			t.execError("Idleness Limit Exceeded")
+			return
+		}
+	}
+}
+
+// Output
+
+// forwardOutput copies the standard output of the accused to w up to
+// spec.Output bytes, and then discards the rest (and the accused is
+// killed).
+func (t *tracer) forwardOutput(r io.Reader, w io.Writer, wg *sync.WaitGroup) {
+	defer wg.Done()
+	if w == nil {
+		w = ioutil.Discard
+	}
+	io.Copy(w, io.LimitReader(r, int64(t.spec.Output)))
+	var b [1]byte
+	if n, _ := r.Read(b[:]); n > 0 {
+		t.fail("Output Limit Exceeded", fmt.Sprintf("More than %d bytes", t.spec.Output))
+	}
+	io.Copy(ioutil.Discard, r)
+}
+
+// forwardStderr copies the standard error to w up to spec.FileSize
+// bytes (as files).
+func (t *tracer) forwardStderr(r io.Reader, w io.Writer, wg *sync.WaitGroup) {
+	defer wg.Done()
+	if w == nil {
+		w = ioutil.Discard
+	}
+	io.Copy(w, io.LimitReader(r, int64(t.spec.FileSize)))
+	io.Copy(ioutil.Discard, r)
+}
+
+// waitTimeout waits for wg at most d (a grandchild might keep a pipe
+// open).
+func waitTimeout(wg *sync.WaitGroup, d time.Duration) {
+	ch := make(chan bool)
+	go func() {
+		wg.Wait()
+		close(ch)
+	}()
+	select {
+	case <-ch:
+	case <-time.After(d):
+	}
+}
+
+// files prepares the standard input, output and error of the accused
+// (the ends of the pipes in 'parent' have to be closed after the
+// fork).
+func (t *tracer) files(wg *sync.WaitGroup) (child []*os.File, parent []*os.File, err error) {
+	closeAll := func() {
+		for _, f := range append(child, parent...) {
+			f.Close()
+		}
+	}
+	var stdin *os.File
+	switch in := t.spec.Stdin.(type) {
+	case *os.File:
+		stdin = in
+	case nil:
+		if stdin, err = os.Open(os.DevNull); err != nil {
+			return nil, nil, err
+		}
+		parent = append(parent, stdin)
+	default:
+		r, w, err := os.Pipe()
+		if err != nil {
+			return nil, nil, err
+		}
+		stdin, parent = r, append(parent, r)
+		go func() {
+			io.Copy(w, in)
+			w.Close()
+		}()
+	}
+	outr, outw, err := os.Pipe()
+	if err != nil {
+		closeAll()
+		return nil, nil, err
+	}
+	errr, errw, err := os.Pipe()
+	if err != nil {
+		outr.Close()
+		outw.Close()
+		closeAll()
+		return nil, nil, err
+	}
+	parent = append(parent, outw, errw)
+	wg.Add(2)
+	go func() {
+		t.forwardOutput(outr, t.spec.Stdout, wg)
+		outr.Close()
+	}()
+	go func() {
+		t.forwardStderr(errr, t.spec.Stderr, wg)
+		errr.Close()
+	}()
+	return []*os.File{stdin, outw, errw}, parent, nil
+}
+
+// Limits
+
+func prlimit(pid int, resource int, max uint64) error {
+	lim := syscall.Rlimit{Cur: max, Max: max + 1}
+	_, _, errno := syscall.RawSyscall6(syscall.SYS_PRLIMIT64, uintptr(pid),
+		uintptr(resource), uintptr(unsafe.Pointer(&lim)), 0, 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func (t *tracer) setLimits() error {
+	cpu := uint64(math.Ceil(t.spec.Time)) // (the ticker is more precise)
+	if err := prlimit(t.pid, syscall.RLIMIT_CPU, cpu); err != nil {
+		return fmt.Errorf("prlimit(RLIMIT_CPU): %s", err)
+	}
+	if err := prlimit(t.pid, syscall.RLIMIT_AS, uint64(t.spec.Memory)); err != nil {
+		return fmt.Errorf("prlimit(RLIMIT_AS): %s", err)
+	}
+	if err := prlimit(t.pid, syscall.RLIMIT_FSIZE, uint64(t.spec.FileSize)); err != nil {
+		return fmt.Errorf("prlimit(RLIMIT_FSIZE): %s", err)
+	}
+	return nil
+}
+
+// run
+
+// run starts 'exe' stopped (with PTRACE_TRACEME), sets the limits, and
+// then traces it (and its children) until it ends.
+func (t *tracer) run(exe string) (Result, error) {
+	// All ptrace requests must come from the same thread
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	var wg sync.WaitGroup
+	child, parent, err := t.files(&wg)
+	if err != nil {
+		return Result{}, fmt.Errorf("Cannot redirect the program: %s", err)
+	}
+	attr := &syscall.ProcAttr{
+		Dir:   t.spec.Dir,
+		Env:   []string{},
+		Files: []uintptr{child[0].Fd(), child[1].Fd(), child[2].Fd()},
+		Sys:   &syscall.SysProcAttr{Ptrace: true, Setpgid: true},
+	}
+	if t.spec.Uid > 0 { // (without supplementary groups)
+		uid := uint32(t.spec.Uid)
+		attr.Sys.Credential = &syscall.Credential{Uid: uid, Gid: uid, Groups: []uint32{}}
+	}
+	t.pid, err = syscall.ForkExec(exe, []string{exe}, attr)
+	for _, f := range parent {
+		f.Close()
+	}
+	if err != nil {
+		waitTimeout(&wg, time.Second)
+		return Result{}, fmt.Errorf("Cannot execute '%s': %s", exe, err)
+	}
+	t.start = time.Now()
+
+	// The first stop is the SIGTRAP after the exec
+	var ws syscall.WaitStatus
+	if _, err := syscall.Wait4(t.pid, &ws, syscall.WALL, nil); err != nil {
+		return t.abort(&wg, fmt.Errorf("wait4: %s", err))
+	}
+	if !ws.Stopped() || ws.StopSignal() != syscall.SIGTRAP {
+		return t.abort(&wg, fmt.Errorf("The program didn't stop after the exec"))
+	}
+	t.tracees[t.pid] = &tracee{started: true}
+	if err := t.setLimits(); err != nil {
+		return t.abort(&wg, err)
+	}
+	if err := syscall.PtraceSetOptions(t.pid, ptraceOptions); err != nil {
+		return t.abort(&wg, fmt.Errorf("ptrace(PTRACE_SETOPTIONS): %s", err))
+	}
+	if err := syscall.PtraceSyscall(t.pid, 0); err != nil {
+		return t.abort(&wg, fmt.Errorf("ptrace(PTRACE_SYSCALL): %s", err))
+	}
+
+	done := make(chan bool)
+	var timer sync.WaitGroup
+	timer.Add(1)
+	go t.checkLimits(done, &timer)
+	err = t.trace()
+	close(done)
+	timer.Wait()
+	if err != nil {
+		return t.abort(&wg, err)
+	}
+	t.reap()
+	waitTimeout(&wg, time.Second)
+	return t.finish(), nil
+}
+
+// abort kills the accused after an internal error.
+func (t *tracer) abort(wg *sync.WaitGroup, err error) (Result, error) {
+	t.reap()
+	waitTimeout(wg, time.Second)
+	t.auditFinish()
+	return Result{}, err
+}
+
+// reap kills all tracees and waits for them.
+func (t *tracer) reap() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	syscall.Kill(-t.pid, syscall.SIGKILL)
+	for pid := range t.tracees {
+		syscall.Kill(pid, syscall.SIGKILL)
+	}
+	var ws syscall.WaitStatus
+	for {
+		_, err := syscall.Wait4(-t.pid, &ws, syscall.WALL, nil)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil { // (ECHILD)
+			break
+		}
+	}
+	t.reaped = true
+}
+
+// trace handles the stops of the tracees until the accused ends.
+func (t *tracer) trace() error {
+	for {
+		var ws syscall.WaitStatus
+		var ru syscall.Rusage
+		pid, err := syscall.Wait4(-t.pid, &ws, syscall.WALL, &ru)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("wait4: %s", err)
+		}
+		switch {
+		case ws.Exited() || ws.Signaled():
+			delete(t.tracees, pid)
+			if pid == t.pid {
+				t.ruUsage = ru
+				if ws.Exited() {
+					t.result.ExitCode = ws.ExitStatus()
+				} else {
+					t.result.Signal = int(ws.Signal())
+				}
+				return nil
+			}
+		case ws.Stopped():
+			if err := t.stopped(pid, ws); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("wait4: unknown status '%d'", ws)
+		}
+	}
+}
+
+func (t *tracer) tracee(pid int) *tracee {
+	T, ok := t.tracees[pid]
+	if !ok {
+		T = &tracee{}
+		t.tracees[pid] = T
+	}
+	return T
+}
+
+var signalReasons = map[syscall.Signal]string{
+	syscall.SIGABRT: "Aborted",
+	syscall.SIGINT:  "Interrupted",
+	syscall.SIGILL:  "Illegal Instruction",
+	syscall.SIGSEGV: "Segmentation Fault",
+	syscall.SIGXCPU: "Time Limit Exceeded",
+	syscall.SIGXFSZ: "File Size Exceeded",
+	syscall.SIGTRAP: "Breakpoint",
+}
+
+func (t *tracer) stopped(pid int, ws syscall.WaitStatus) error {
+	T := t.tracee(pid)
+	sig := ws.StopSignal()
+	deliver := 0
+	switch {
+	case ws.TrapCause() > 0:
+		switch ws.TrapCause() {
+		case syscall.PTRACE_EVENT_FORK, syscall.PTRACE_EVENT_VFORK, syscall.PTRACE_EVENT_CLONE:
+			child, err := syscall.PtraceGetEventMsg(pid)
+			if err == syscall.ESRCH {
+				return nil // (killed meanwhile)
+			} else if err != nil {
+				return fmt.Errorf("ptrace(PTRACE_GETEVENTMSG): %s", err)
+			}
+			t.tracee(int(child)) // (it might have stopped already)
+		}
+	case sig == syscall.SIGSTOP && !T.started:
+		T.started = true // (children inherit the options)
+	case sig == syscall.SIGTRAP|0x80:
+		T.inSyscall = !T.inSyscall
+		var err error
+		if T.inSyscall {
+			err = t.beforeSyscall(pid, T)
+		} else {
+			err = t.afterSyscall(pid, T)
+		}
+		if err != nil {
+			return err
+		}
+	default:
+		if reason, ok := signalReasons[sig]; ok {
+			t.result.Signal = int(sig)
+			t.execError(reason)
+			return nil
+		}
+		deliver = int(sig) // e.g. SIGCHLD
+	}
+	if t.hasFailed() {
+		return nil // (killed)
+	}
+	syscall.PtraceSyscall(pid, deliver) // (it might have been killed)
+	return nil
+}
+
+// filename reads a string (up to maxFilename bytes) from the memory
+// of the tracee, page by page.
+func filename(pid int, addr uint64) (string, error) {
+	var name []byte
+	for len(name) < maxFilename {
+		n := pageSize - int(addr%pageSize)
+		if len(name)+n > maxFilename {
+			n = maxFilename - len(name)
+		}
+		buf := make([]byte, n)
+		m, err := syscall.PtracePeekData(pid, uintptr(addr), buf)
+		if m == 0 {
+			if err == nil {
+				err = syscall.EFAULT
+			}
+			return "", err
+		}
+		if i := strings.IndexByte(string(buf[:m]), 0); i >= 0 {
+			return string(append(name, buf[:i]...)), nil
+		}
+		name = append(name, buf[:m]...)
+		addr += uint64(m)
+	}
+	return "", errTooLong
+}
+
+var errTooLong = fmt.Errorf("name too long")
+
+func (t *tracer) beforeSyscall(pid int, T *tracee) error {
+	r, err := getRegs(pid)
+	if err == syscall.ESRCH {
+		return nil // (killed meanwhile)
+	} else if err != nil {
+		return fmt.Errorf("ptrace(PTRACE_GETREGS): %s", err)
+	}
+	abi := r.abi(pid)
+	nr := r.number()
+	T.sys = nr
+	name := ""
+	if abi == nativeABI {
+		name = syscallName(nr)
+	}
+	if name != "" {
+		t.result.Syscalls[name]++
+	}
+	if name == "exit" || name == "exit_group" {
+		t.samplePeak()
+	}
+	s, err := repr(abi, name, nr, r.args(), func(addr uint64) (string, error) {
+		return filename(pid, addr)
+	})
+	if err == errTooLong {
+		t.execError("Access to file with name too long")
+		return nil
+	} else if err != nil {
+		t.execError("Access to file with name out of memory")
+		return nil
+	}
+
+	wrongABI := abi != nativeABI
+	forbidden := wrongABI || (t.spec.Accused && !t.allowed(s))
+	if t.spec.Audit != nil {
+		ms := t.elapsedMs()
+		suffix := ""
+		if forbidden {
+			suffix = " FORBIDDEN"
+		}
+		t.auditAdd(fmt.Sprintf("%d.%03d [%d] %s%s\n", ms/1000, ms%1000, pid, s, suffix))
+	}
+	if wrongABI {
+		t.result.Forbidden = s
+		t.execError(fmt.Sprintf("Forbidden Syscall ABI '%s'", abi))
+		return nil
+	}
+	if t.spec.Accused {
+		if forbidden {
+			t.result.Forbidden = s
+			t.execError(fmt.Sprintf("Forbidden Syscall '%s'", s))
+		}
+	} else if !t.syscalls[s] {
+		t.syscalls[s] = true
+		if _, err := fmt.Fprintln(t.record, s); err != nil {
+			return fmt.Errorf("Couldn't write to '%s': %s", SyscallsFile, err)
+		}
+	}
+	return nil
+}
+
+func (t *tracer) afterSyscall(pid int, T *tracee) error {
+	r, err := getRegs(pid)
+	if err == syscall.ESRCH {
+		return nil // (killed meanwhile)
+	} else if err != nil {
+		return fmt.Errorf("ptrace(PTRACE_GETREGS): %s", err)
+	}
+	if r.number() == ^uint64(0) {
+		return nil // (e.g. after rt_sigreturn)
+	}
+	// (after an exec, the number might be that of another ABI)
+	name := syscallName(T.sys)
+	if r.number() != T.sys && name != "execve" {
+		t.execError("Mismatched syscall before/after")
+		return nil
+	}
+	switch name {
+	case "brk", "mmap", "mremap":
+		if r.result() == -int64(syscall.ENOMEM) {
+			t.execError("Memory Limit Exceeded")
+		}
+	}
+	return nil
+}
+
+func (t *tracer) auditAdd(line string) {
+	if t.audit == nil {
+		t.audit = make([]string, auditLines)
+	}
+	t.audit[t.nAudit%auditLines] = line
+	t.nAudit++
+}
+
+// auditFinish writes the last lines of the audit log in order.
+func (t *tracer) auditFinish() {
+	first := 0
+	if t.nAudit > auditLines {
+		first = t.nAudit - auditLines
+	}
+	for i := first; i < t.nAudit; i++ {
+		io.WriteString(t.spec.Audit, t.audit[i%auditLines])
+	}
+}
+
+// finish decides the status of the accused after it ended.
+func (t *tracer) finish() Result {
+	t.mu.Lock()
+	R := t.result
+	R.MemoryKb = t.peakKb
+	t.mu.Unlock()
+	utime := time.Duration(t.ruUsage.Utime.Nano())
+	stime := time.Duration(t.ruUsage.Stime.Nano())
+	R.CpuMs = int((utime + stime) / time.Millisecond)
+	R.WallMs = t.elapsedMs()
+	R.PeakRssKb = int(t.ruUsage.Maxrss)
+	t.auditFinish()
+	switch {
+	case R.Status != "": // (failed)
+	case R.Signal != 0:
+		R.Status, R.Reason = "Execution Error", fmt.Sprintf("Signalled %d", R.Signal)
+	case R.CpuMs > int(t.spec.Time*1000): // (the ticker is periodic)
+		R.Status, R.Reason = "Execution Error", "Time Limit Exceeded"
+	case R.ExitCode != 0:
+		R.Status, R.Reason = "Non-Zero Status", ""
+	default:
+		R.Status, R.Reason = "Ok", ""
+	}
+	return R
+}